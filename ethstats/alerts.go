@@ -0,0 +1,462 @@
+package ethstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	AlertNoBlock      = "no_block"
+	AlertBlocksBehind = "blocks_behind"
+	AlertLowPeers     = "low_peers"
+	AlertSyncing      = "syncing"
+	AlertReorgDepth   = "reorg_depth"
+	AlertOffline      = "offline"
+)
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+const (
+	WebhookJSON      = "json"
+	WebhookSlack     = "slack"
+	WebhookPagerDuty = "pagerduty"
+)
+
+// pagerDutyEventsURL is the endpoint of the PagerDuty Events API v2
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// webhookTimeout is the time to wait for a webhook to accept a notification
+const webhookTimeout = 10 * time.Second
+
+// AlertRules are the conditions evaluated for each node, a zero value disables the rule
+type AlertRules struct {
+	// NoBlock fires when the node has not reported a new block for the duration
+	NoBlock time.Duration
+
+	// BlocksBehind fires when the latest block of the node is more than
+	// the given number of blocks behind the highest block of all the nodes
+	BlocksBehind int
+
+	// MinPeers fires when the node reports less peers
+	MinPeers int
+
+	// SyncingFor fires when the node reports it is syncing for longer than the duration
+	SyncingFor time.Duration
+
+	// ReorgDepth fires when the node reports a reorg deeper than the depth. The
+	// alert is resolved at the next evaluation without a new deep reorg.
+	ReorgDepth int
+
+	// OfflineFor fires when the node is disconnected for longer than the duration
+	OfflineFor time.Duration
+}
+
+func (r AlertRules) enabled() bool {
+	return r.NoBlock > 0 || r.BlocksBehind > 0 || r.MinPeers > 0 || r.SyncingFor > 0 || r.ReorgDepth > 0 || r.OfflineFor > 0
+}
+
+// AlertConfig is the configuration of the alerts
+type AlertConfig struct {
+	Rules AlertRules
+
+	// Interval is how often the rules are evaluated (0 disables the alerts)
+	Interval time.Duration
+
+	// Webhooks are notified when an alert fires or is resolved
+	Webhooks []*AlertWebhook
+}
+
+// AlertWebhook is an http endpoint notified of the alerts
+type AlertWebhook struct {
+	URL string
+
+	// Format of the notifications: 'json' (the alert, default), 'slack'
+	// (incoming webhook message) or 'pagerduty' (Events API v2 event)
+	Format string
+
+	// RoutingKey is the integration key of the PagerDuty service
+	RoutingKey string
+}
+
+// ParseAlertWebhook parses a webhook from comma separated key=value pairs with
+// the keys 'url', 'format' and 'routing-key', i.e. url=https://hooks.slack.com/...,format=slack
+func ParseAlertWebhook(str string) (*AlertWebhook, error) {
+	values, err := parseKeyValues(str, "url", "format", "routing-key")
+	if err != nil {
+		return nil, err
+	}
+	w := &AlertWebhook{
+		URL:        values["url"],
+		Format:     values["format"],
+		RoutingKey: values["routing-key"],
+	}
+	if w.Format == "" {
+		w.Format = WebhookJSON
+	}
+
+	switch w.Format {
+	case WebhookJSON, WebhookSlack:
+		if w.URL == "" {
+			return nil, fmt.Errorf("webhook url not set")
+		}
+	case WebhookPagerDuty:
+		if w.RoutingKey == "" {
+			return nil, fmt.Errorf("pagerduty routing key not set")
+		}
+		if w.URL == "" {
+			w.URL = pagerDutyEventsURL
+		}
+	default:
+		return nil, fmt.Errorf("webhook format '%s' not found", w.Format)
+	}
+	return w, nil
+}
+
+// Alert is a rule that holds for a node
+type Alert struct {
+	Rule     string     `json:"rule"`
+	NodeID   string     `json:"node"`
+	Status   string     `json:"status"`
+	Message  string     `json:"message"`
+	StartsAt time.Time  `json:"startsAt"`
+	EndsAt   *time.Time `json:"endsAt,omitempty"`
+}
+
+func (a *Alert) key() string {
+	return a.Rule + "/" + a.NodeID
+}
+
+// alertNode is the latest data of a node used to evaluate the rules. The
+// nodes are still evaluated once they disconnect with their latest data.
+type alertNode struct {
+	seenAt        time.Time
+	connected     bool
+	offlineAt     time.Time
+	offlineReason string
+	hasBlock      bool
+	blockNumber   int
+	blockAt       time.Time
+	hasStats      bool
+	peers         int
+	syncingSince  time.Time
+	reorgDepth    int
+}
+
+// alertEngine evaluates the alert rules over the data ingested from the
+// nodes and notifies the webhooks when the alerts fire or are resolved
+type alertEngine struct {
+	logger  hclog.Logger
+	metrics *metrics
+	config  AlertConfig
+	client  *http.Client
+
+	lock   sync.Mutex
+	nodes  map[string]*alertNode
+	active map[string]*Alert
+}
+
+func newAlertEngine(logger hclog.Logger, metrics *metrics, config AlertConfig) *alertEngine {
+	return &alertEngine{
+		logger:  logger,
+		metrics: metrics,
+		config:  config,
+		client:  &http.Client{Timeout: webhookTimeout},
+		nodes:   map[string]*alertNode{},
+		active:  map[string]*Alert{},
+	}
+}
+
+// node returns the data of the node, the lock has to be held
+func (e *alertEngine) node(nodeID string) *alertNode {
+	node, ok := e.nodes[nodeID]
+	if !ok {
+		now := time.Now()
+		node = &alertNode{seenAt: now, blockAt: now, connected: true}
+		e.nodes[nodeID] = node
+	}
+	return node
+}
+
+// observeNode starts tracking the node or marks it as connected again
+func (e *alertEngine) observeNode(nodeID string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	node := e.node(nodeID)
+	node.connected = true
+	node.offlineAt = time.Time{}
+	node.offlineReason = ""
+}
+
+// observeBlock records a block (or a head) reported by the node
+func (e *alertEngine) observeBlock(nodeID string, number int) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	node := e.node(nodeID)
+	if !node.hasBlock || number > node.blockNumber {
+		node.hasBlock = true
+		node.blockNumber = number
+		node.blockAt = time.Now()
+	}
+}
+
+// observeStats records the stats reported by the node
+func (e *alertEngine) observeStats(nodeID string, stats *NodeStats) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	node := e.node(nodeID)
+	node.hasStats = true
+	node.peers = stats.Peers
+	if !stats.Syncing {
+		node.syncingSince = time.Time{}
+	} else if node.syncingSince.IsZero() {
+		node.syncingSince = time.Now()
+	}
+}
+
+// observeReorg records the deepest reorg of the node since the last evaluation
+func (e *alertEngine) observeReorg(reorg *Reorg) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	node := e.node(reorg.NodeID)
+	if reorg.Depth > node.reorgDepth {
+		node.reorgDepth = reorg.Depth
+	}
+}
+
+// disconnectNode marks the node as offline since now with the reason its session
+// closed. Its alerts are not resolved since the node still has to be fixed.
+func (e *alertEngine) disconnectNode(nodeID string, reason string, now time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	node, ok := e.nodes[nodeID]
+	if !ok {
+		return
+	}
+	node.connected = false
+	node.offlineAt = now
+	node.offlineReason = reason
+}
+
+// check returns whether the rule holds for the node and the message of the alert
+func (e *alertEngine) check(rule string, node *alertNode, head int, now time.Time) (bool, string) {
+	rules := e.config.Rules
+
+	switch rule {
+	case AlertNoBlock:
+		if since := now.Sub(node.blockAt); rules.NoBlock > 0 && since > rules.NoBlock {
+			if !node.hasBlock {
+				return true, fmt.Sprintf("no block reported for %s since it connected", since.Round(time.Second))
+			}
+			return true, fmt.Sprintf("no new block for %s (latest %d)", since.Round(time.Second), node.blockNumber)
+		}
+
+	case AlertBlocksBehind:
+		// the node is not behind until it reports its first block
+		if behind := head - node.blockNumber; rules.BlocksBehind > 0 && node.hasBlock && behind > rules.BlocksBehind {
+			return true, fmt.Sprintf("%d blocks behind the head %d", behind, head)
+		}
+
+	case AlertLowPeers:
+		if rules.MinPeers > 0 && node.hasStats && node.peers < rules.MinPeers {
+			return true, fmt.Sprintf("%d peers (minimum %d)", node.peers, rules.MinPeers)
+		}
+
+	case AlertSyncing:
+		if !node.syncingSince.IsZero() && rules.SyncingFor > 0 && now.Sub(node.syncingSince) > rules.SyncingFor {
+			return true, fmt.Sprintf("syncing for %s", now.Sub(node.syncingSince).Round(time.Second))
+		}
+
+	case AlertReorgDepth:
+		if rules.ReorgDepth > 0 && node.reorgDepth > rules.ReorgDepth {
+			return true, fmt.Sprintf("reorg of depth %d (maximum %d)", node.reorgDepth, rules.ReorgDepth)
+		}
+
+	case AlertOffline:
+		if since := now.Sub(node.offlineAt); rules.OfflineFor > 0 && !node.connected && since > rules.OfflineFor {
+			return true, fmt.Sprintf("disconnected for %s (%s)", since.Round(time.Second), node.offlineReason)
+		}
+	}
+	return false, ""
+}
+
+// evaluate checks the rules for every node and returns the alerts
+// that fired and the ones that were resolved since the last evaluation
+func (e *alertEngine) evaluate(now time.Time) []*Alert {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	head := 0
+	for _, node := range e.nodes {
+		if node.blockNumber > head {
+			head = node.blockNumber
+		}
+	}
+
+	changed := []*Alert{}
+	for nodeID, node := range e.nodes {
+		for _, rule := range []string{AlertNoBlock, AlertBlocksBehind, AlertLowPeers, AlertSyncing, AlertReorgDepth, AlertOffline} {
+			holds, message := e.check(rule, node, head, now)
+
+			key := (&Alert{Rule: rule, NodeID: nodeID}).key()
+			active, isActive := e.active[key]
+
+			switch {
+			case holds && !isActive:
+				alert := &Alert{
+					Rule:     rule,
+					NodeID:   nodeID,
+					Status:   AlertFiring,
+					Message:  message,
+					StartsAt: now.UTC(),
+				}
+				e.active[key] = alert
+				changed = append(changed, alert)
+
+			case !holds && isActive:
+				delete(e.active, key)
+
+				resolved := *active
+				endsAt := now.UTC()
+				resolved.Status = AlertResolved
+				resolved.EndsAt = &endsAt
+				changed = append(changed, &resolved)
+			}
+		}
+		// only the reorgs since the last evaluation are checked
+		node.reorgDepth = 0
+	}
+
+	e.updateFiring()
+
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].key() < changed[j].key()
+	})
+	return changed
+}
+
+// updateFiring sets the number of alerts firing by rule, the lock has to be held
+func (e *alertEngine) updateFiring() {
+	firing := map[string]int{}
+	for _, alert := range e.active {
+		firing[alert.Rule]++
+	}
+	e.metrics.setAlertsFiring(firing)
+}
+
+// activeAlerts returns the alerts that are firing sorted by rule and node
+func (e *alertEngine) activeAlerts() []*Alert {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	alerts := []*Alert{}
+	for _, alert := range e.active {
+		alertCopy := *alert
+		alerts = append(alerts, &alertCopy)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].key() < alerts[j].key()
+	})
+	return alerts
+}
+
+// webhookPayload returns the body sent to the webhook for the alert
+func webhookPayload(webhook *AlertWebhook, alert *Alert) interface{} {
+	switch webhook.Format {
+	case WebhookSlack:
+		return map[string]string{
+			"text": fmt.Sprintf("[%s] %s on node %s: %s", strings.ToUpper(alert.Status), alert.Rule, alert.NodeID, alert.Message),
+		}
+
+	case WebhookPagerDuty:
+		action := "trigger"
+		if alert.Status == AlertResolved {
+			action = "resolve"
+		}
+		return map[string]interface{}{
+			"routing_key":  webhook.RoutingKey,
+			"event_action": action,
+			"dedup_key":    "ethstats/" + alert.key(),
+			"payload": map[string]interface{}{
+				"summary":   fmt.Sprintf("%s on node %s: %s", alert.Rule, alert.NodeID, alert.Message),
+				"source":    alert.NodeID,
+				"severity":  "warning",
+				"timestamp": alert.StartsAt.Format(time.RFC3339),
+			},
+		}
+
+	default:
+		return alert
+	}
+}
+
+// notify sends the alerts to all the webhooks
+func (e *alertEngine) notify(alerts []*Alert) {
+	for _, alert := range alerts {
+		e.logger.Info("alert "+alert.Status, "rule", alert.Rule, "node", alert.NodeID, "msg", alert.Message)
+
+		for _, webhook := range e.config.Webhooks {
+			if err := e.send(webhook, alert); err != nil {
+				e.logger.Error("failed to notify webhook", "url", webhook.URL, "rule", alert.Rule, "node", alert.NodeID, "err", err)
+				e.metrics.alertNotificationFailed()
+			}
+		}
+	}
+}
+
+func (e *alertEngine) send(webhook *AlertWebhook, alert *Alert) error {
+	data, err := json.Marshal(webhookPayload(webhook, alert))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(webhook.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runAlerts periodically evaluates the alert rules until the server is closed
+func (s *Server) runAlerts() {
+	ticker := time.NewTicker(s.config.Alerts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.alerts.notify(s.alerts.evaluate(time.Now()))
+
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// handleAlerts returns the alerts that are firing. The requests have
+// to be authenticated with the admin token once it is set.
+func (s *Server) handleAlerts(r *http.Request) (interface{}, error) {
+	if err := s.checkAdminToken(r, false); err != nil {
+		return nil, err
+	}
+	return s.alerts.activeAlerts(), nil
+}
@@ -0,0 +1,321 @@
+package ethstats
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAlertWebhook(t *testing.T) {
+	w, err := ParseAlertWebhook("url=http://localhost/hook")
+	assert.NoError(t, err)
+	assert.Equal(t, w, &AlertWebhook{URL: "http://localhost/hook", Format: WebhookJSON})
+
+	w, err = ParseAlertWebhook("format=pagerduty,routing-key=abcd")
+	assert.NoError(t, err)
+	assert.Equal(t, w, &AlertWebhook{URL: pagerDutyEventsURL, Format: WebhookPagerDuty, RoutingKey: "abcd"})
+
+	for _, str := range []string{"", "format=slack", "format=pagerduty", "url=http://localhost,format=xml", "url=http://localhost,other=1"} {
+		_, err := ParseAlertWebhook(str)
+		assert.Error(t, err)
+	}
+}
+
+func testAlertEngine(rules AlertRules, webhooks ...*AlertWebhook) *alertEngine {
+	return newAlertEngine(hclog.NewNullLogger(), newMetrics(), AlertConfig{Rules: rules, Webhooks: webhooks})
+}
+
+func alertKeys(alerts []*Alert) []string {
+	keys := []string{}
+	for _, alert := range alerts {
+		keys = append(keys, alert.Status+":"+alert.key())
+	}
+	return keys
+}
+
+func TestAlertEngine_Evaluate(t *testing.T) {
+	e := testAlertEngine(AlertRules{
+		NoBlock:      5 * time.Minute,
+		BlocksBehind: 5,
+		MinPeers:     3,
+		SyncingFor:   time.Minute,
+		ReorgDepth:   2,
+	})
+
+	e.observeBlock("a", 100)
+	e.observeBlock("b", 90)
+	e.observeStats("a", &NodeStats{Peers: 5})
+	e.observeStats("b", &NodeStats{Peers: 1, Syncing: true})
+	e.observeReorg(&Reorg{NodeID: "a", Depth: 2})
+	e.observeReorg(&Reorg{NodeID: "a", Depth: 3})
+
+	now := time.Now()
+	assert.Equal(t, alertKeys(e.evaluate(now)), []string{
+		"firing:blocks_behind/b",
+		"firing:low_peers/b",
+		"firing:reorg_depth/a",
+	})
+
+	// the reorg alert only holds until the next evaluation
+	assert.Equal(t, alertKeys(e.evaluate(now)), []string{
+		"resolved:reorg_depth/a",
+	})
+	assert.Empty(t, e.evaluate(now))
+	assert.Equal(t, alertKeys(e.activeAlerts()), []string{
+		"firing:blocks_behind/b",
+		"firing:low_peers/b",
+	})
+
+	// b catches up but keeps syncing
+	e.observeBlock("b", 99)
+	e.observeStats("b", &NodeStats{Peers: 4, Syncing: true})
+	changed := e.evaluate(now.Add(2 * time.Minute))
+	assert.Equal(t, alertKeys(changed), []string{
+		"resolved:blocks_behind/b",
+		"resolved:low_peers/b",
+		"firing:syncing/b",
+	})
+	assert.Equal(t, changed[0].StartsAt, now.UTC())
+	assert.Equal(t, *changed[0].EndsAt, now.Add(2*time.Minute).UTC())
+
+	// neither node reports new blocks
+	assert.Equal(t, alertKeys(e.evaluate(now.Add(10*time.Minute))), []string{
+		"firing:no_block/a",
+		"firing:no_block/b",
+	})
+
+	// a reports a new block, b stops syncing and its last block is recent again
+	e.observeBlock("a", 101)
+	e.observeStats("b", &NodeStats{Peers: 4})
+	assert.Equal(t, alertKeys(e.evaluate(time.Now())), []string{
+		"resolved:no_block/a",
+		"resolved:no_block/b",
+		"resolved:syncing/b",
+	})
+	assert.Empty(t, e.activeAlerts())
+}
+
+func TestAlertEngine_FirstBlock(t *testing.T) {
+	e := testAlertEngine(AlertRules{NoBlock: 5 * time.Minute, BlocksBehind: 5})
+
+	// b connects but has not reported a block yet
+	e.observeBlock("a", 100)
+	e.observeNode("b")
+
+	now := time.Now()
+	assert.Empty(t, e.evaluate(now))
+
+	e.observeBlock("b", 90)
+	assert.Equal(t, alertKeys(e.evaluate(now)), []string{
+		"firing:blocks_behind/b",
+	})
+
+	// a node that never reports a block has no new block either
+	e.observeNode("c")
+	changed := e.evaluate(now.Add(10 * time.Minute))
+	assert.Contains(t, alertKeys(changed), "firing:no_block/c")
+	assert.NotContains(t, alertKeys(changed), "firing:blocks_behind/c")
+}
+
+func TestAlertEngine_DisconnectNode(t *testing.T) {
+	e := testAlertEngine(AlertRules{NoBlock: 5 * time.Minute, BlocksBehind: 5, OfflineFor: time.Minute})
+
+	e.observeBlock("a", 100)
+	e.observeBlock("b", 90)
+
+	now := time.Now()
+	assert.Equal(t, alertKeys(e.evaluate(now)), []string{"firing:blocks_behind/b"})
+
+	// the alerts of the node are kept when it disconnects
+	e.disconnectNode("b", SessionReasonIdle, now)
+	assert.Empty(t, e.evaluate(now))
+	assert.Equal(t, alertKeys(e.activeAlerts()), []string{"firing:blocks_behind/b"})
+
+	changed := e.evaluate(now.Add(2 * time.Minute))
+	assert.Equal(t, alertKeys(changed), []string{"firing:offline/b"})
+	assert.Equal(t, changed[0].Message, "disconnected for 2m0s (idle)")
+
+	// and the rules are still evaluated while it is offline
+	assert.Equal(t, alertKeys(e.evaluate(now.Add(10*time.Minute))), []string{
+		"firing:no_block/a",
+		"firing:no_block/b",
+	})
+
+	// the node connects again
+	e.observeNode("b")
+	e.observeBlock("a", 101)
+	e.observeBlock("b", 101)
+	assert.Equal(t, alertKeys(e.evaluate(time.Now())), []string{
+		"resolved:blocks_behind/b",
+		"resolved:no_block/a",
+		"resolved:no_block/b",
+		"resolved:offline/b",
+	})
+
+	// an unknown node is not tracked
+	e.disconnectNode("c", SessionReasonClosed, now)
+	assert.Empty(t, e.evaluate(time.Now()))
+}
+
+func TestAlertEngine_Disabled(t *testing.T) {
+	e := testAlertEngine(AlertRules{})
+	assert.False(t, e.config.Rules.enabled())
+
+	e.observeBlock("a", 100)
+	e.observeBlock("b", 1)
+	e.observeStats("b", &NodeStats{Syncing: true})
+	e.observeReorg(&Reorg{NodeID: "a", Depth: 10})
+	assert.Empty(t, e.evaluate(time.Now().Add(time.Hour)))
+}
+
+// webhookServer is a local stand-in of the webhooks that records the notifications
+type webhookServer struct {
+	*httptest.Server
+
+	lock   sync.Mutex
+	status int
+	bodies []map[string]interface{}
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	w := &webhookServer{status: http.StatusOK}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, r.Header.Get("Content-Type"), "application/json")
+
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal(data, &body))
+
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		w.bodies = append(w.bodies, body)
+		rw.WriteHeader(w.status)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhookServer) received() []map[string]interface{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	bodies := w.bodies
+	w.bodies = nil
+	return bodies
+}
+
+func TestAlertEngine_Notify(t *testing.T) {
+	hook := newWebhookServer(t)
+
+	e := testAlertEngine(AlertRules{MinPeers: 3},
+		&AlertWebhook{URL: hook.URL, Format: WebhookJSON},
+		&AlertWebhook{URL: hook.URL, Format: WebhookSlack},
+		&AlertWebhook{URL: hook.URL, Format: WebhookPagerDuty, RoutingKey: "abcd"},
+	)
+
+	e.observeStats("a", &NodeStats{Peers: 1})
+	e.notify(e.evaluate(time.Now()))
+
+	bodies := hook.received()
+	assert.Len(t, bodies, 3)
+
+	assert.Equal(t, bodies[0]["rule"], AlertLowPeers)
+	assert.Equal(t, bodies[0]["node"], "a")
+	assert.Equal(t, bodies[0]["status"], AlertFiring)
+	assert.Equal(t, bodies[0]["message"], "1 peers (minimum 3)")
+	assert.NotContains(t, bodies[0], "endsAt")
+
+	assert.Equal(t, bodies[1]["text"], "[FIRING] low_peers on node a: 1 peers (minimum 3)")
+
+	assert.Equal(t, bodies[2]["routing_key"], "abcd")
+	assert.Equal(t, bodies[2]["event_action"], "trigger")
+	assert.Equal(t, bodies[2]["dedup_key"], "ethstats/low_peers/a")
+	assert.Equal(t, bodies[2]["payload"].(map[string]interface{})["source"], "a")
+
+	e.observeStats("a", &NodeStats{Peers: 3})
+	e.notify(e.evaluate(time.Now()))
+
+	bodies = hook.received()
+	assert.Len(t, bodies, 3)
+	assert.Equal(t, bodies[0]["status"], AlertResolved)
+	assert.Contains(t, bodies[0], "endsAt")
+	assert.Equal(t, bodies[2]["event_action"], "resolve")
+	assert.Equal(t, bodies[2]["dedup_key"], "ethstats/low_peers/a")
+}
+
+func TestAlertEngine_NotifyFailed(t *testing.T) {
+	hook := newWebhookServer(t)
+	hook.status = http.StatusInternalServerError
+
+	e := testAlertEngine(AlertRules{MinPeers: 3}, &AlertWebhook{URL: hook.URL, Format: WebhookJSON})
+	assert.Error(t, e.send(e.config.Webhooks[0], &Alert{Rule: AlertLowPeers, NodeID: "a"}))
+
+	e.observeStats("a", &NodeStats{Peers: 1})
+	e.notify(e.evaluate(time.Now()))
+	assert.Len(t, hook.received(), 2)
+
+	// the alert is still active even if the notification failed
+	assert.Len(t, e.activeAlerts(), 1)
+}
+
+func TestServer_Alerts(t *testing.T) {
+	srv := newTestServer(t)
+	srv.alerts = testAlertEngine(AlertRules{BlocksBehind: 5})
+
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 100, "hash": "0x100"}}`))
+	srv.handleMessage("b", mustDecodeMsg(t, "headEvent", `{"event": {"added": [{"number": 10, "hash": "0x10"}], "removed": [], "type": "head"}}`))
+	srv.alerts.evaluate(time.Now())
+
+	res, err := srv.handleAlerts(httptest.NewRequest(http.MethodGet, "/api/v1/alerts", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, alertKeys(res.([]*Alert)), []string{"firing:blocks_behind/b"})
+
+	// the alerts are only shown with the admin token once it is set
+	srv.config.AdminToken = "token"
+	_, err = srv.handleAlerts(httptest.NewRequest(http.MethodGet, "/api/v1/alerts", nil))
+	assert.Error(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/alerts", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	res, err = srv.handleAlerts(r)
+	assert.NoError(t, err)
+	assert.Len(t, res.([]*Alert), 1)
+}
+
+func TestServer_AlertsSessionClosed(t *testing.T) {
+	hook := newWebhookServer(t)
+
+	srv := newTestServer(t)
+	srv.alerts = testAlertEngine(AlertRules{BlocksBehind: 5, OfflineFor: time.Minute}, &AlertWebhook{URL: hook.URL, Format: WebhookJSON})
+
+	session := newWsSession("b", &mockWsWriter{})
+	assert.NoError(t, srv.sessionStarted(session))
+
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 100, "hash": "0x100"}}`))
+	srv.handleMessage("b", mustDecodeMsg(t, "block", `{"block": {"number": 10, "hash": "0x10"}}`))
+	srv.alerts.notify(srv.alerts.evaluate(time.Now()))
+	assert.Len(t, hook.received(), 1)
+
+	// the alert of b keeps firing when its session closes
+	session.setCloseReason(SessionReasonClosed)
+	srv.sessionClosed(session)
+	assert.True(t, srv.waitTasks(context.Background()))
+	assert.Len(t, hook.received(), 0)
+	assert.Len(t, srv.alerts.activeAlerts(), 1)
+
+	// and b is reported offline
+	srv.alerts.notify(srv.alerts.evaluate(time.Now().Add(2 * time.Minute)))
+	bodies := hook.received()
+	assert.Len(t, bodies, 1)
+	assert.Equal(t, bodies[0]["rule"], AlertOffline)
+	assert.Equal(t, bodies[0]["status"], AlertFiring)
+}
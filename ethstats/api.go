@@ -0,0 +1,467 @@
+package ethstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// defaultAPILimit is the number of items returned by the list endpoints
+	defaultAPILimit = 50
+
+	// maxAPILimit is the maximum number of items returned by the list endpoints
+	maxAPILimit = 500
+
+	// defaultPropagationBlocks is the number of blocks used for the propagation stats
+	defaultPropagationBlocks = 100
+
+	// maxPropagationBlocks is the maximum number of blocks used for the propagation stats
+	maxPropagationBlocks = 10000
+)
+
+// apiHandler serves a read-only json api over the data in the Store
+type apiHandler struct {
+	logger hclog.Logger
+	state  Store
+}
+
+func (a *apiHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/blocks", a.get(a.handleBlocks))
+	mux.HandleFunc("/api/v1/blocks/", a.get(a.handleBlock))
+	mux.HandleFunc("/api/v1/propagation", a.get(a.handlePropagation))
+	mux.HandleFunc("/api/v1/canonical/", a.get(a.handleCanonical))
+	mux.HandleFunc("/api/v1/nodes", a.get(a.handleNodes))
+	mux.HandleFunc("/api/v1/nodes/", a.get(a.handleNode))
+	mux.HandleFunc("/api/v1/headevents", a.get(a.handleHeadEvents))
+	mux.HandleFunc("/api/v1/headevents/", a.get(a.handleHeadEvent))
+	mux.HandleFunc("/api/v1/reorgs", a.get(a.handleReorgs))
+	mux.HandleFunc("/api/v1/splits", a.get(a.handleChainSplits))
+	mux.HandleFunc("/api/v1/rollups", a.get(a.handleChainRollups))
+}
+
+// apiError is an error with the http status code to return
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func errBadRequest(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+func errUnauthorized(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusUnauthorized, err: fmt.Errorf(format, args...)}
+}
+
+func errNotFound(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusNotFound, err: fmt.Errorf(format, args...)}
+}
+
+func errNotImplemented(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusNotImplemented, err: fmt.Errorf(format, args...)}
+}
+
+// get wraps an api handler that only accepts GET requests
+func (a *apiHandler) get(handler func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return a.handle(http.MethodGet, handler)
+}
+
+// post wraps an api handler that only accepts POST requests
+func (a *apiHandler) post(handler func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return a.handle(http.MethodPost, handler)
+}
+
+// handle wraps an api handler that only accepts requests with the
+// method and writes either the returned object or the error as json
+func (a *apiHandler) handle(method string, handler func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		obj, err := handler(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if apiErr, ok := err.(*apiError); ok {
+				status = apiErr.status
+			} else {
+				a.logger.Error("failed to handle api request", "path", r.URL.Path, "err", err)
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, obj)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(obj)
+}
+
+// pathParams returns the segments of the path after the prefix
+func pathParams(r *http.Request, prefix string) []string {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func queryInt(r *http.Request, key string, defaultVal int) (int, error) {
+	str := r.URL.Query().Get(key)
+	if str == "" {
+		return defaultVal, nil
+	}
+	val, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, errBadRequest("failed to parse '%s': %v", key, err)
+	}
+	return val, nil
+}
+
+// queryTime parses a RFC3339 time, the zero time is returned if the parameter is not set
+func queryTime(r *http.Request, key string) (time.Time, error) {
+	str := r.URL.Query().Get(key)
+	if str == "" {
+		return time.Time{}, nil
+	}
+	val, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return time.Time{}, errBadRequest("failed to parse '%s': %v", key, err)
+	}
+	return val, nil
+}
+
+// queryRollupPeriod parses the 'period' of the rollups, hourly by default
+func queryRollupPeriod(r *http.Request) (RollupPeriod, error) {
+	str := r.URL.Query().Get("period")
+	if str == "" {
+		return RollupHourly, nil
+	}
+	period, err := ParseRollupPeriod(str)
+	if err != nil {
+		return "", errBadRequest("%v", err)
+	}
+	return period, nil
+}
+
+func queryLimit(r *http.Request) (int, error) {
+	limit, err := queryInt(r, "limit", defaultAPILimit)
+	if err != nil {
+		return 0, err
+	}
+	if limit <= 0 || limit > maxAPILimit {
+		return 0, errBadRequest("limit must be between 1 and %d", maxAPILimit)
+	}
+	return limit, nil
+}
+
+// handleBlocks returns the blocks in the [from, to] range from newest to oldest
+func (a *apiHandler) handleBlocks(r *http.Request) (interface{}, error) {
+	from, err := queryInt(r, "from", 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := queryInt(r, "to", math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, errBadRequest("'from' is greater than 'to'")
+	}
+	limit, err := queryLimit(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.state.ListBlocks(from, to, limit)
+}
+
+// handleBlock serves both /blocks/{hash} and /blocks/{hash}/propagation
+func (a *apiHandler) handleBlock(r *http.Request) (interface{}, error) {
+	params := pathParams(r, "/api/v1/blocks/")
+
+	switch {
+	case len(params) == 1:
+		block, err := a.state.GetBlock(params[0])
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			return nil, errNotFound("block %s not found", params[0])
+		}
+		return block, nil
+
+	case len(params) == 2 && params[1] == "propagation":
+		arrivals, err := a.state.GetBlockArrivals(params[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(arrivals) == 0 {
+			return nil, errNotFound("block %s not found", params[0])
+		}
+		return blockPropagation(arrivals), nil
+
+	default:
+		return nil, errNotFound("not found")
+	}
+}
+
+// handlePropagation returns the propagation stats of each node over the blocks
+// in the [from, to] range. By default, the range are the latest blocks up to the
+// head of the canonical chain.
+func (a *apiHandler) handlePropagation(r *http.Request) (interface{}, error) {
+	to, err := queryInt(r, "to", -1)
+	if err != nil {
+		return nil, err
+	}
+	if to < 0 {
+		head, err := a.state.GetCanonicalHead()
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			return []*PropagationStats{}, nil
+		}
+		to = head.Number
+	}
+	from, err := queryInt(r, "from", to-defaultPropagationBlocks+1)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, errBadRequest("'from' is greater than 'to'")
+	}
+	if to-from >= maxPropagationBlocks {
+		return nil, errBadRequest("the range can include up to %d blocks", maxPropagationBlocks)
+	}
+
+	arrivals, err := a.state.ListBlockArrivals(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return propagationStats(arrivals), nil
+}
+
+// handleCanonical serves both /canonical/{number} and /canonical/head
+func (a *apiHandler) handleCanonical(r *http.Request) (interface{}, error) {
+	params := pathParams(r, "/api/v1/canonical/")
+	if len(params) != 1 {
+		return nil, errNotFound("not found")
+	}
+
+	var block *Block
+	var err error
+	if params[0] == "head" {
+		block, err = a.state.GetCanonicalHead()
+	} else {
+		number, perr := strconv.Atoi(params[0])
+		if perr != nil {
+			return nil, errBadRequest("failed to parse block number: %v", perr)
+		}
+		block, err = a.state.GetCanonicalBlock(number)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errNotFound("canonical block %s not found", params[0])
+	}
+	return block, nil
+}
+
+func (a *apiHandler) handleNodes(r *http.Request) (interface{}, error) {
+	return a.state.ListNodes()
+}
+
+// handleNode serves /nodes/{id} and the /stats, /stats/history, /latency, /pending and /rollups of the node
+func (a *apiHandler) handleNode(r *http.Request) (interface{}, error) {
+	params := pathParams(r, "/api/v1/nodes/")
+
+	switch {
+	case len(params) == 1:
+		info, err := a.state.GetNodeInfo(params[0])
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			return nil, errNotFound("node %s not found", params[0])
+		}
+		return info, nil
+
+	case len(params) == 2 && params[1] == "latency":
+		since, err := queryTime(r, "since")
+		if err != nil {
+			return nil, err
+		}
+		limit, err := queryLimit(r)
+		if err != nil {
+			return nil, err
+		}
+		return a.state.ListNodeLatency(params[0], since, limit)
+
+	case len(params) == 2 && params[1] == "pending":
+		since, err := queryTime(r, "since")
+		if err != nil {
+			return nil, err
+		}
+		limit, err := queryLimit(r)
+		if err != nil {
+			return nil, err
+		}
+		return a.state.ListNodePending(params[0], since, limit)
+
+	case len(params) == 2 && params[1] == "sessions":
+		limit, err := queryLimit(r)
+		if err != nil {
+			return nil, err
+		}
+		return a.state.ListSessionEvents(params[0], r.URL.Query().Get("before"), limit)
+
+	case len(params) == 2 && params[1] == "rollups":
+		period, err := queryRollupPeriod(r)
+		if err != nil {
+			return nil, err
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			return nil, err
+		}
+		before, err := queryTime(r, "before")
+		if err != nil {
+			return nil, err
+		}
+		limit, err := queryLimit(r)
+		if err != nil {
+			return nil, err
+		}
+		return a.state.ListNodeRollups(params[0], period, from, before, limit)
+
+	case len(params) == 2 && params[1] == "stats":
+		at, err := queryTime(r, "at")
+		if err != nil {
+			return nil, err
+		}
+		if !at.IsZero() {
+			// stats reported by the node at a given time
+			snapshot, err := a.state.GetNodeStatsAt(params[0], at)
+			if err != nil {
+				return nil, err
+			}
+			if snapshot == nil {
+				return nil, errNotFound("node %s has no stats at %s", params[0], at.Format(time.RFC3339))
+			}
+			return snapshot, nil
+		}
+
+		stats, err := a.state.GetNodeStats(params[0])
+		if err != nil {
+			return nil, err
+		}
+		if stats == nil {
+			return nil, errNotFound("node %s not found", params[0])
+		}
+		return stats, nil
+
+	case len(params) == 3 && params[1] == "stats" && params[2] == "history":
+		from, err := queryTime(r, "from")
+		if err != nil {
+			return nil, err
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			return nil, err
+		}
+		limit, err := queryLimit(r)
+		if err != nil {
+			return nil, err
+		}
+		return a.state.ListNodeStatsHistory(params[0], from, to, limit)
+
+	default:
+		return nil, errNotFound("not found")
+	}
+}
+
+// handleHeadEvents returns the head events from newest to oldest. The events
+// can be paginated using the id of the last event as the 'before' parameter.
+func (a *apiHandler) handleHeadEvents(r *http.Request) (interface{}, error) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	return a.state.ListHeadEvents(query.Get("node"), query.Get("before"), limit)
+}
+
+func (a *apiHandler) handleHeadEvent(r *http.Request) (interface{}, error) {
+	params := pathParams(r, "/api/v1/headevents/")
+	if len(params) != 1 {
+		return nil, errNotFound("not found")
+	}
+
+	evnt, err := a.state.GetHeadEvent(params[0])
+	if err != nil {
+		return nil, err
+	}
+	if evnt == nil {
+		return nil, errNotFound("head event %s not found", params[0])
+	}
+	return evnt, nil
+}
+
+// handleReorgs returns the reorgs from newest to oldest. The reorgs can
+// be paginated using the event id of the last reorg as the 'before' parameter.
+func (a *apiHandler) handleReorgs(r *http.Request) (interface{}, error) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	return a.state.ListReorgs(query.Get("node"), query.Get("before"), limit)
+}
+
+// handleChainSplits returns the chain splits from newest to oldest. The splits
+// can be paginated using the id of the last split as the 'before' parameter.
+func (a *apiHandler) handleChainSplits(r *http.Request) (interface{}, error) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.state.ListChainSplits(r.URL.Query().Get("before"), limit)
+}
+
+// handleChainRollups returns the chain rollups of the period from newest to oldest. The
+// rollups can be paginated using the bucket of the last rollup as the 'before' parameter.
+func (a *apiHandler) handleChainRollups(r *http.Request) (interface{}, error) {
+	period, err := queryRollupPeriod(r)
+	if err != nil {
+		return nil, err
+	}
+	from, err := queryTime(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	before, err := queryTime(r, "before")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := queryLimit(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.state.ListChainRollups(period, from, before, limit)
+}
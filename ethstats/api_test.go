@@ -0,0 +1,256 @@
+package ethstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAPI(t *testing.T, state Store) *httptest.Server {
+	api := &apiHandler{
+		logger: hclog.NewNullLogger(),
+		state:  state,
+	}
+	mux := http.NewServeMux()
+	api.register(mux)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func apiGet(t *testing.T, url string, out interface{}) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestAPI_Blocks(t *testing.T) {
+	state := NewMemoryState()
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, state.WriteBlock(config, &Block{Number: i, Hash: fmt.Sprintf("0x%d", i), Txs: []TxStats{{Hash: "0xa"}}}))
+	}
+	srv := newTestAPI(t, state)
+
+	var blocks []*Block
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks?from=2&to=3", &blocks), http.StatusOK)
+	assert.Len(t, blocks, 2)
+	assert.Equal(t, blocks[0].Number, 3)
+
+	blocks = nil
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks?limit=1", &blocks), http.StatusOK)
+	assert.Len(t, blocks, 1)
+
+	var block Block
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks/0x2", &block), http.StatusOK)
+	assert.Equal(t, block.Number, 2)
+	assert.Len(t, block.Txs, 1)
+
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks/0x9", nil), http.StatusNotFound)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks?from=a", nil), http.StatusBadRequest)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks?from=3&to=1", nil), http.StatusBadRequest)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks?limit=100000", nil), http.StatusBadRequest)
+
+	resp, err := http.Post(srv.URL+"/api/v1/blocks", "application/json", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+}
+
+func TestAPI_Canonical(t *testing.T) {
+	state := NewMemoryState()
+	for _, hash := range []string{"0x1a", "0x1b"} {
+		assert.NoError(t, state.WriteBlock(config, &Block{Number: 1, Hash: hash}))
+	}
+	assert.NoError(t, state.SetBlockStatus("0x1b", BlockStatusCanonical))
+	srv := newTestAPI(t, state)
+
+	var block Block
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/canonical/1", &block), http.StatusOK)
+	assert.Equal(t, block.Hash, "0x1b")
+	assert.Equal(t, block.Status, BlockStatusCanonical)
+
+	block = Block{}
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/canonical/head", &block), http.StatusOK)
+	assert.Equal(t, block.Hash, "0x1b")
+
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/canonical/2", nil), http.StatusNotFound)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/canonical/abc", nil), http.StatusBadRequest)
+}
+
+func TestAPI_Propagation(t *testing.T) {
+	state := NewMemoryState()
+	now := time.Now().UTC()
+	for i, node := range []string{"a", "b"} {
+		assert.NoError(t, state.WriteBlockArrival(&BlockArrival{
+			NodeID:      node,
+			BlockHash:   "0x1",
+			BlockNumber: 1,
+			ReceivedAt:  now.Add(time.Duration(i) * time.Second),
+		}))
+	}
+	srv := newTestAPI(t, state)
+
+	var propagation BlockPropagation
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks/0x1/propagation", &propagation), http.StatusOK)
+	assert.Equal(t, propagation.FirstNode, "a")
+	assert.Len(t, propagation.Arrivals, 2)
+	assert.Equal(t, propagation.Arrivals[1].Delay, int64(1000))
+
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/blocks/0x2/propagation", nil), http.StatusNotFound)
+
+	// without a canonical head there is no default range
+	var stats []*PropagationStats
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/propagation", &stats), http.StatusOK)
+	assert.Len(t, stats, 0)
+
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/propagation?from=0&to=10", &stats), http.StatusOK)
+	assert.Len(t, stats, 2)
+	assert.Equal(t, stats[0].FirstSeen, 1)
+	assert.Equal(t, stats[1].DelayP50, int64(1000))
+
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/propagation?from=0&to=100000", nil), http.StatusBadRequest)
+}
+
+func TestAPI_Nodes(t *testing.T) {
+	state := NewMemoryState()
+	assert.NoError(t, state.WriteNodeInfo(&NodeInfo{Name: "a", Client: "bor"}))
+	assert.NoError(t, state.WriteNodeStats("a", &NodeStats{Peers: 10}))
+	srv := newTestAPI(t, state)
+
+	var nodes []*NodeInfo
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes", &nodes), http.StatusOK)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, nodes[0].Client, "bor")
+
+	var info NodeInfo
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a", &info), http.StatusOK)
+	assert.Equal(t, info.Name, "a")
+
+	var stats NodeStats
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/stats", &stats), http.StatusOK)
+	assert.Equal(t, stats.Peers, 10)
+
+	assert.NoError(t, state.WriteNodeLatency(&NodeLatency{NodeID: "a", Latency: 25, ReportedAt: time.Now().UTC()}))
+
+	var latencies []*NodeLatency
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/latency", &latencies), http.StatusOK)
+	assert.Len(t, latencies, 1)
+	assert.Equal(t, latencies[0].Latency, 25)
+
+	latencies = nil
+	since := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/latency?since="+since, &latencies), http.StatusOK)
+	assert.Len(t, latencies, 0)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/latency?since=yesterday", nil), http.StatusBadRequest)
+
+	assert.NoError(t, state.WriteNodePending(&NodePending{NodeID: "a", Pending: 7, ReportedAt: time.Now().UTC()}))
+
+	var pending []*NodePending
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/pending", &pending), http.StatusOK)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, pending[0].Pending, 7)
+
+	var history []*NodeStatsSnapshot
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/stats/history", &history), http.StatusOK)
+	assert.Len(t, history, 1)
+	assert.Equal(t, history[0].Peers, 10)
+
+	var snapshot NodeStatsSnapshot
+	at := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/stats?at="+at, &snapshot), http.StatusOK)
+	assert.Equal(t, snapshot.Peers, 10)
+
+	at = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/stats?at="+at, nil), http.StatusNotFound)
+
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/b", nil), http.StatusNotFound)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/b/stats", nil), http.StatusNotFound)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/other", nil), http.StatusNotFound)
+}
+
+func TestAPI_Rollups(t *testing.T) {
+	state := NewMemoryState()
+	assert.NoError(t, state.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, state.WriteNodeStats("a", &NodeStats{Peers: 10}))
+
+	bucket := RollupHourly.truncate(time.Now())
+	assert.NoError(t, state.WriteRollup(RollupHourly, bucket.Add(-time.Hour)))
+	assert.NoError(t, state.WriteRollup(RollupHourly, bucket))
+	srv := newTestAPI(t, state)
+
+	var rollups []*ChainRollup
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/rollups?limit=1", &rollups), http.StatusOK)
+	assert.Len(t, rollups, 1)
+	assert.Equal(t, rollups[0].Period, RollupHourly)
+	assert.True(t, rollups[0].Bucket.Equal(bucket))
+
+	rollups = nil
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/rollups?before="+bucket.Format(time.RFC3339), &rollups), http.StatusOK)
+	assert.Len(t, rollups, 1)
+	assert.True(t, rollups[0].Bucket.Equal(bucket.Add(-time.Hour)))
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/rollups?before=yesterday", nil), http.StatusBadRequest)
+
+	rollups = nil
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/rollups?period=day", &rollups), http.StatusOK)
+	assert.Len(t, rollups, 0)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/rollups?period=week", nil), http.StatusBadRequest)
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/rollups?limit=0", nil), http.StatusBadRequest)
+
+	var nodeRollups []*NodeRollup
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/rollups?from="+bucket.Format(time.RFC3339), &nodeRollups), http.StatusOK)
+	assert.Len(t, nodeRollups, 1)
+	assert.Equal(t, nodeRollups[0].AvgPeers, float64(10))
+}
+
+func TestAPI_NodeSessions(t *testing.T) {
+	state := NewMemoryState()
+	for i, typ := range []string{SessionConnected, SessionDisconnected} {
+		assert.NoError(t, state.WriteSessionEvent(&SessionEvent{ID: fmt.Sprintf("0%d", i), NodeID: "a", Type: typ}))
+	}
+	srv := newTestAPI(t, state)
+
+	var events []*SessionEvent
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/sessions?limit=1", &events), http.StatusOK)
+	assert.Len(t, events, 1)
+	assert.Equal(t, events[0].Type, SessionDisconnected)
+
+	events = nil
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/nodes/a/sessions?before=01", &events), http.StatusOK)
+	assert.Len(t, events, 1)
+	assert.Equal(t, events[0].Type, SessionConnected)
+}
+
+func TestAPI_HeadEvents(t *testing.T) {
+	state := NewMemoryState()
+	idA, err := state.WriteHeadEvent("a", &HeadEvent{Type: "head", Added: []BlockStub{{Hash: "0x1", Number: 1}}})
+	assert.NoError(t, err)
+	_, err = state.WriteHeadEvent("b", &HeadEvent{Type: "head"})
+	assert.NoError(t, err)
+	srv := newTestAPI(t, state)
+
+	var events []*NodeHeadEvent
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/headevents?node=a", &events), http.StatusOK)
+	assert.Len(t, events, 1)
+	assert.Equal(t, events[0].ID, idA)
+	assert.Equal(t, events[0].Added[0].Hash, "0x1")
+
+	var evnt HeadEvent
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/headevents/"+idA, &evnt), http.StatusOK)
+	assert.Equal(t, evnt.Type, "head")
+
+	assert.Equal(t, apiGet(t, srv.URL+"/api/v1/headevents/unknown", nil), http.StatusNotFound)
+}
@@ -0,0 +1,197 @@
+package ethstats
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// nodeAuth authenticates the nodes either with the shared secret of the collector or,
+// if a credentials file is set, with the secret of each node in the file. The file is a
+// json object from the node names to their secrets and is reloaded when it changes.
+type nodeAuth struct {
+	// lock protects all the fields since the auth is replaced when the config is reloaded
+	lock sync.RWMutex
+
+	// secret is the shared secret of the nodes (if not empty) when there is no credentials file
+	secret string
+
+	// path is the credentials file (optional)
+	path string
+
+	// allow are the only nodes accepted (if not empty) and deny the nodes always rejected
+	allow map[string]struct{}
+	deny  map[string]struct{}
+
+	credentials map[string]string
+	modTime     time.Time
+}
+
+func newNodeAuth(secret, path string, allow, deny []string) (*nodeAuth, error) {
+	toSet := func(names []string) map[string]struct{} {
+		res := map[string]struct{}{}
+		for _, name := range names {
+			res[name] = struct{}{}
+		}
+		return res
+	}
+
+	a := &nodeAuth{
+		secret: secret,
+		path:   path,
+		allow:  toSet(allow),
+		deny:   toSet(deny),
+	}
+	if path != "" {
+		if _, err := a.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// reload reads again the credentials file if it was modified since the last read
+func (a *nodeAuth) reload() (bool, error) {
+	a.lock.RLock()
+	path, modTime := a.path, a.modTime
+	a.lock.RUnlock()
+
+	if path == "" {
+		return false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(modTime) {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	credentials := map[string]string{}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return false, fmt.Errorf("failed to decode credentials file %s: %v", path, err)
+	}
+	for name, secret := range credentials {
+		if secret == "" {
+			return false, fmt.Errorf("node '%s' has no secret", name)
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// the file is discarded if it was replaced in the meantime
+	if a.path != path {
+		return false, nil
+	}
+	a.credentials = credentials
+	a.modTime = info.ModTime()
+
+	return true, nil
+}
+
+// replace sets the secrets, the credentials and the allowed nodes of the other auth
+func (a *nodeAuth) replace(other *nodeAuth) {
+	other.lock.RLock()
+	defer other.lock.RUnlock()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.secret = other.secret
+	a.path = other.path
+	a.allow = other.allow
+	a.deny = other.deny
+	a.credentials = other.credentials
+	a.modTime = other.modTime
+}
+
+// credentialsFile returns the path of the credentials file (if any)
+func (a *nodeAuth) credentialsFile() string {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	return a.path
+}
+
+// authenticate returns an error if the node is not allowed or the secret is not correct
+func (a *nodeAuth) authenticate(nodeID, secret string) error {
+	if a == nil {
+		return nil
+	}
+	if nodeID == "" {
+		return fmt.Errorf("node name not set")
+	}
+
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if _, ok := a.deny[nodeID]; ok {
+		return fmt.Errorf("node '%s' is denied", nodeID)
+	}
+	if len(a.allow) != 0 {
+		if _, ok := a.allow[nodeID]; !ok {
+			return fmt.Errorf("node '%s' is not allowed", nodeID)
+		}
+	}
+
+	expected := a.secret
+	if a.path != "" {
+		nodeSecret, ok := a.credentials[nodeID]
+		if !ok {
+			return fmt.Errorf("node '%s' has no credentials", nodeID)
+		}
+		expected = nodeSecret
+	}
+	if expected != "" && !secretsEqual(secret, expected) {
+		return fmt.Errorf("secret of node '%s' is not correct", nodeID)
+	}
+	return nil
+}
+
+// secretsEqual compares the secrets in constant time
+func secretsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// revokeSessions closes the sessions of the nodes that cannot authenticate anymore
+func (s *Server) revokeSessions() {
+	for _, session := range s.activeSessions() {
+		if err := s.auth.authenticate(session.nodeID, session.secret); err != nil {
+			s.logger.Info("closing revoked session", "node", session.nodeID, "reason", err)
+			session.close(SessionReasonRevoked)
+		}
+	}
+}
+
+// runCredentialsReload periodically reloads the credentials file until the server is closed
+func (s *Server) runCredentialsReload() {
+	ticker := time.NewTicker(s.config.CredentialsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := s.auth.reload()
+			if err != nil {
+				s.logger.Error("failed to reload credentials", "err", err)
+				continue
+			}
+			if reloaded {
+				s.logger.Info("credentials reloaded", "path", s.auth.credentialsFile())
+				s.revokeSessions()
+			}
+
+		case <-s.closeCh:
+			return
+		}
+	}
+}
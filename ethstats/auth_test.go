@@ -0,0 +1,95 @@
+package ethstats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeAuth_Secret(t *testing.T) {
+	auth, err := newNodeAuth("secret", "", []string{"a", "b"}, []string{"b"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, auth.authenticate("a", "secret"))
+	assert.Error(t, auth.authenticate("a", "other"))
+
+	// only the allowed nodes that are not denied are accepted
+	assert.Error(t, auth.authenticate("b", "secret"))
+	assert.Error(t, auth.authenticate("c", "secret"))
+	assert.Error(t, auth.authenticate("", "secret"))
+
+	// without auth all the nodes are accepted
+	var noAuth *nodeAuth
+	assert.NoError(t, noAuth.authenticate("a", ""))
+}
+
+func TestNodeAuth_Credentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"a": "secret-a", "b": "secret-b"}`), 0600))
+
+	auth, err := newNodeAuth("secret", path, nil, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, auth.authenticate("a", "secret-a"))
+	assert.Error(t, auth.authenticate("a", "secret-b"))
+	assert.Error(t, auth.authenticate("a", "secret"))
+	assert.Error(t, auth.authenticate("c", "secret"))
+
+	// the file is only read again once it is modified
+	reloaded, err := auth.reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"b": "secret-b2"}`), 0600))
+	modTime := time.Now().Add(time.Second)
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	reloaded, err = auth.reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+
+	assert.Error(t, auth.authenticate("a", "secret-a"))
+	assert.NoError(t, auth.authenticate("b", "secret-b2"))
+
+	// an invalid file keeps the previous credentials
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"b": ""}`), 0600))
+	modTime = modTime.Add(time.Second)
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	_, err = auth.reload()
+	assert.Error(t, err)
+	assert.NoError(t, auth.authenticate("b", "secret-b2"))
+}
+
+// mockWsConn is a session connection that records if it was closed
+type mockWsConn struct {
+	mockWsWriter
+	closed bool
+}
+
+func (m *mockWsConn) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestServer_RevokeSessions(t *testing.T) {
+	srv := newTestServer(t)
+
+	var err error
+	srv.auth, err = newNodeAuth("", "", nil, []string{"b"})
+	assert.NoError(t, err)
+
+	conns := map[string]*mockWsConn{}
+	for _, node := range []string{"a", "b"} {
+		conns[node] = &mockWsConn{}
+		assert.NoError(t, srv.sessionStarted(newWsSession(node, conns[node])))
+	}
+
+	srv.revokeSessions()
+	assert.False(t, conns["a"].closed)
+	assert.True(t, conns["b"].closed)
+}
@@ -0,0 +1,290 @@
+package ethstats
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// BlockStatusUnknown is the status of the blocks not evaluated yet
+	BlockStatusUnknown = "unknown"
+
+	// BlockStatusCanonical is the status of the blocks in the canonical chain
+	BlockStatusCanonical = "canonical"
+
+	// BlockStatusOrphaned is the status of the blocks replaced in the canonical chain
+	BlockStatusOrphaned = "orphaned"
+
+	// BlockStatusUncle is the status of the blocks included as uncles by a canonical block
+	BlockStatusUncle = "uncle"
+)
+
+// maxCanonicalWalk is the maximum number of ancestors visited
+// when a new head is set in the canonical chain
+const maxCanonicalWalk = 256
+
+// canonicalChain maintains the status of the blocks in the store. The block with the
+// highest number (reported either in a 'block' message or a 'headEvent') is the head
+// of the canonical chain and its ancestors are found with the parent hashes.
+// The canonical blocks older than the finality depth are marked as finalized
+// and their status does not change anymore.
+type canonicalChain struct {
+	lock sync.Mutex
+
+	logger        hclog.Logger
+	state         Store
+	finalityDepth int
+
+	// head is the head of the canonical chain, nil if it is not known yet
+	head *BlockStub
+
+	// pending are the statuses of the blocks referenced by other blocks
+	// or head events before the block itself is stored
+	pending map[string]pendingStatus
+
+	// rollups are marked stale when the status of a block changes
+	rollups *staleRollups
+}
+
+type pendingStatus struct {
+	number int
+	status string
+}
+
+func newCanonicalChain(logger hclog.Logger, state Store, finalityDepth int, rollups *staleRollups) *canonicalChain {
+	return &canonicalChain{
+		logger:        logger,
+		state:         state,
+		finalityDepth: finalityDepth,
+		pending:       map[string]pendingStatus{},
+		rollups:       rollups,
+	}
+}
+
+// loadHead returns the head of the canonical chain, either the
+// one tracked or the highest canonical block in the store
+func (c *canonicalChain) loadHead() (*BlockStub, error) {
+	if c.head != nil {
+		return c.head, nil
+	}
+	block, err := c.state.GetCanonicalHead()
+	if err != nil {
+		return nil, err
+	}
+	if block != nil {
+		c.head = &BlockStub{Number: block.Number, Hash: block.Hash, ParentHash: block.ParentHash}
+	}
+	return c.head, nil
+}
+
+// addBlock updates the canonical chain with a block written in the store
+func (c *canonicalChain) addBlock(block *Block) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	head, err := c.loadHead()
+	if err != nil {
+		return err
+	}
+
+	stub := BlockStub{Number: block.Number, Hash: block.Hash, ParentHash: block.ParentHash}
+
+	// the block may have been referenced before it was stored
+	pending, isPending := c.pending[block.Hash]
+	if isPending {
+		delete(c.pending, block.Hash)
+	}
+
+	if head == nil || block.Number > head.Number {
+		if err := c.setHead(stub); err != nil {
+			return err
+		}
+	} else if isPending && pending.status == BlockStatusCanonical {
+		// the ancestors of the block may have arrived before it
+		if _, err := c.markCanonical(stub); err != nil {
+			return err
+		}
+	} else if isPending {
+		if err := c.setBlockStatus(block, pending.status); err != nil {
+			return err
+		}
+	} else {
+		current, err := c.state.GetBlock(block.Hash)
+		if err != nil {
+			return err
+		}
+		if current != nil && current.Status != BlockStatusCanonical {
+			// the block is not in the canonical chain if there is
+			// another canonical block at the same height
+			canonical, err := c.state.GetCanonicalBlock(block.Number)
+			if err != nil {
+				return err
+			}
+			if canonical != nil && canonical.Hash != block.Hash {
+				if err := c.setBlockStatus(current, BlockStatusOrphaned); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, uncle := range block.Uncles {
+		if err := c.setStatus(BlockStub{Number: uncle.Number, Hash: uncle.Hash}, BlockStatusUncle); err != nil {
+			return err
+		}
+	}
+	return c.finalize()
+}
+
+// applyHeadEvent updates the canonical chain with the blocks added and removed by the
+// head event. The event is applied only if the new head is not lower than the current one.
+func (c *canonicalChain) applyHeadEvent(evnt *HeadEvent) error {
+	if len(evnt.Added) == 0 {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	head, err := c.loadHead()
+	if err != nil {
+		return err
+	}
+
+	added := sortedStubs(evnt.Added)
+	newHead := added[len(added)-1]
+	if head != nil && newHead.Number < head.Number {
+		return nil
+	}
+
+	for _, stub := range evnt.Removed {
+		if err := c.setStatus(stub, BlockStatusOrphaned); err != nil {
+			return err
+		}
+	}
+	if err := c.setHead(newHead); err != nil {
+		return err
+	}
+	return c.finalize()
+}
+
+// setHead sets the block as the head of the canonical chain. The head
+// is not set if its chain conflicts with a finalized block.
+func (c *canonicalChain) setHead(stub BlockStub) error {
+	ok, err := c.markCanonical(stub)
+	if err != nil || !ok {
+		return err
+	}
+
+	if c.head == nil || c.head.Hash != stub.Hash {
+		c.logger.Debug("new canonical head", "number", stub.Number, "hash", stub.Hash)
+	}
+	c.head = &stub
+
+	// the pending statuses too old to be referenced again are discarded
+	for hash, pending := range c.pending {
+		if pending.number < stub.Number-maxCanonicalWalk {
+			delete(c.pending, hash)
+		}
+	}
+	return nil
+}
+
+// markCanonical marks the block as canonical and walks back its ancestors until
+// it finds a block already in the canonical chain. The blocks replaced at each
+// height are marked as orphaned. It returns false if the chain of the block
+// conflicts with a finalized block, in which case nothing is changed.
+func (c *canonicalChain) markCanonical(stub BlockStub) (bool, error) {
+	type replacement struct {
+		stub BlockStub
+		prev *Block
+	}
+
+	// find the blocks of the new chain that are not canonical yet
+	branch := []replacement{}
+	cur := stub
+	for i := 0; i < maxCanonicalWalk; i++ {
+		block, err := c.state.GetBlock(cur.Hash)
+		if err != nil {
+			return false, err
+		}
+		if block != nil {
+			if i != 0 && (block.Status == BlockStatusCanonical || block.Finalized) {
+				break
+			}
+			cur.ParentHash = block.ParentHash
+		}
+
+		prev, err := c.state.GetCanonicalBlock(cur.Number)
+		if err != nil {
+			return false, err
+		}
+		if prev != nil && prev.Hash == cur.Hash {
+			prev = nil
+		}
+		if prev != nil && prev.Finalized {
+			c.logger.Error("chain conflicts with a finalized block", "number", cur.Number, "hash", cur.Hash, "finalized", prev.Hash, "head", stub.Hash)
+			return false, nil
+		}
+		branch = append(branch, replacement{stub: cur, prev: prev})
+
+		if cur.ParentHash == "" || cur.Number == 0 {
+			break
+		}
+		cur = BlockStub{Number: cur.Number - 1, Hash: cur.ParentHash}
+	}
+
+	for _, r := range branch {
+		if r.prev != nil {
+			if err := c.setBlockStatus(r.prev, BlockStatusOrphaned); err != nil {
+				return false, err
+			}
+		}
+		if err := c.setStatus(r.stub, BlockStatusCanonical); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// setStatus sets the status of the block or keeps it as pending if the block is not stored yet
+func (c *canonicalChain) setStatus(stub BlockStub, status string) error {
+	block, err := c.state.GetBlock(stub.Hash)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		c.pending[stub.Hash] = pendingStatus{number: stub.Number, status: status}
+		return nil
+	}
+	if block.Status == status {
+		return nil
+	}
+	return c.setBlockStatus(block, status)
+}
+
+// setBlockStatus sets the status of a stored block and marks its rollups as stale
+func (c *canonicalChain) setBlockStatus(block *Block, status string) error {
+	if err := c.state.SetBlockStatus(block.Hash, status); err != nil {
+		return err
+	}
+	c.rollups.blockChanged(block.Timestamp)
+	return nil
+}
+
+// finalize marks as finalized the canonical blocks older than the finality depth
+func (c *canonicalChain) finalize() error {
+	if c.finalityDepth <= 0 || c.head == nil {
+		return nil
+	}
+	number := c.head.Number - c.finalityDepth
+	if number < 0 {
+		return nil
+	}
+	if err := c.state.FinalizeBlocks(number); err != nil {
+		return fmt.Errorf("failed to finalize blocks: %v", err)
+	}
+	return nil
+}
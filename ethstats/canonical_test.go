@@ -0,0 +1,144 @@
+package ethstats
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCanonicalChain(t *testing.T, finalityDepth int) (*canonicalChain, Store) {
+	state := NewMemoryState()
+	return newCanonicalChain(hclog.NewNullLogger(), state, finalityDepth, nil), state
+}
+
+func writeCanonicalBlock(t *testing.T, c *canonicalChain, state Store, block *Block) {
+	assert.NoError(t, state.WriteBlock(config, block))
+	assert.NoError(t, c.addBlock(block))
+}
+
+func assertBlockStatus(t *testing.T, state Store, hash, status string) {
+	t.Helper()
+
+	block, err := state.GetBlock(hash)
+	assert.NoError(t, err)
+	if assert.NotNil(t, block) {
+		assert.Equal(t, status, block.Status, hash)
+	}
+}
+
+func TestCanonicalChain_Blocks(t *testing.T) {
+	c, state := newTestCanonicalChain(t, 0)
+
+	writeCanonicalBlock(t, c, state, &Block{Number: 1, Hash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2a", ParentHash: "0x1"})
+
+	// a sibling at the same height does not replace the head
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2b", ParentHash: "0x1"})
+	assertBlockStatus(t, state, "0x2a", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x2b", BlockStatusOrphaned)
+
+	// the chain of 0x2b gets longer and replaces 0x2a
+	writeCanonicalBlock(t, c, state, &Block{Number: 3, Hash: "0x3b", ParentHash: "0x2b"})
+	assertBlockStatus(t, state, "0x1", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x2a", BlockStatusOrphaned)
+	assertBlockStatus(t, state, "0x2b", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x3b", BlockStatusCanonical)
+
+	block, err := state.GetCanonicalBlock(2)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, "0x2b")
+}
+
+func TestCanonicalChain_HeadEvent(t *testing.T) {
+	c, state := newTestCanonicalChain(t, 0)
+
+	writeCanonicalBlock(t, c, state, &Block{Number: 1, Hash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2a", ParentHash: "0x1"})
+
+	// the head event reorgs to 0x2b before the block is stored
+	assert.NoError(t, c.applyHeadEvent(&HeadEvent{
+		Added:   []BlockStub{{Number: 2, Hash: "0x2b", ParentHash: "0x1"}},
+		Removed: []BlockStub{{Number: 2, Hash: "0x2a", ParentHash: "0x1"}},
+	}))
+	assertBlockStatus(t, state, "0x2a", BlockStatusOrphaned)
+
+	block, err := state.GetCanonicalBlock(2)
+	assert.NoError(t, err)
+	assert.Nil(t, block)
+
+	// the pending status is applied once the block arrives
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2b", ParentHash: "0x1"})
+	assertBlockStatus(t, state, "0x2b", BlockStatusCanonical)
+}
+
+func TestCanonicalChain_Uncles(t *testing.T) {
+	c, state := newTestCanonicalChain(t, 0)
+
+	writeCanonicalBlock(t, c, state, &Block{Number: 1, Hash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2u", ParentHash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2", ParentHash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{
+		Number:     3,
+		Hash:       "0x3",
+		ParentHash: "0x2",
+		Uncles:     []Block{{Number: 2, Hash: "0x2u"}},
+	})
+
+	assertBlockStatus(t, state, "0x2", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x2u", BlockStatusUncle)
+}
+
+func TestCanonicalChain_Finality(t *testing.T) {
+	c, state := newTestCanonicalChain(t, 2)
+
+	writeCanonicalBlock(t, c, state, &Block{Number: 1, Hash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2a", ParentHash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 3, Hash: "0x3a", ParentHash: "0x2a"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 4, Hash: "0x4a", ParentHash: "0x3a"})
+
+	block, err := state.GetBlock("0x2a")
+	assert.NoError(t, err)
+	assert.True(t, block.Finalized)
+
+	block, err = state.GetBlock("0x3a")
+	assert.NoError(t, err)
+	assert.False(t, block.Finalized)
+
+	// a longer chain that conflicts with a finalized block does not replace it
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2b", ParentHash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 3, Hash: "0x3b", ParentHash: "0x2b"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 4, Hash: "0x4b", ParentHash: "0x3b"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 5, Hash: "0x5b", ParentHash: "0x4b"})
+
+	assertBlockStatus(t, state, "0x2a", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x3a", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x4a", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x2b", BlockStatusOrphaned)
+	assertBlockStatus(t, state, "0x5b", BlockStatusUnknown)
+}
+
+func TestCanonicalChain_LateAncestors(t *testing.T) {
+	c, state := newTestCanonicalChain(t, 0)
+
+	writeCanonicalBlock(t, c, state, &Block{Number: 1, Hash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 2, Hash: "0x2", ParentHash: "0x1"})
+	writeCanonicalBlock(t, c, state, &Block{Number: 3, Hash: "0x3a", ParentHash: "0x2"})
+
+	// the new head references 0x4 before it is stored
+	writeCanonicalBlock(t, c, state, &Block{Number: 5, Hash: "0x5", ParentHash: "0x4"})
+
+	// the parent of 0x4 arrives first and is not canonical yet
+	writeCanonicalBlock(t, c, state, &Block{Number: 3, Hash: "0x3b", ParentHash: "0x2"})
+	assertBlockStatus(t, state, "0x3b", BlockStatusOrphaned)
+
+	// 0x4 links the head to 0x3b, which replaces 0x3a
+	writeCanonicalBlock(t, c, state, &Block{Number: 4, Hash: "0x4", ParentHash: "0x3b"})
+	assertBlockStatus(t, state, "0x4", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x3b", BlockStatusCanonical)
+	assertBlockStatus(t, state, "0x3a", BlockStatusOrphaned)
+
+	block, err := state.GetCanonicalBlock(3)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, "0x3b")
+}
@@ -0,0 +1,362 @@
+package ethstats
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultChainViewDepth is the number of blocks tracked for each node
+const defaultChainViewDepth = 128
+
+// ChainSplit is a divergence between the chains followed by the nodes
+type ChainSplit struct {
+	ID string `json:"id" db:"split_id"`
+
+	// Height is the block number where the divergence was detected
+	Height int `json:"height" db:"height"`
+
+	// ForkNumber and ForkHash are the last block shared by all the
+	// branches. ForkNumber is -1 if the fork point is unknown.
+	ForkNumber int    `json:"forkNumber" db:"fork_number"`
+	ForkHash   string `json:"forkHash" db:"fork_hash"`
+
+	// Branches are the nodes that follow each branch indexed
+	// by the hash of their block at the split height
+	Branches splitBranches `json:"branches" db:"branches"`
+
+	// ResolvedAt is set once the nodes follow the same chain again or the
+	// split falls out of the blocks tracked before they converge
+	DetectedAt time.Time  `json:"detectedAt" db:"detected_at"`
+	ResolvedAt *time.Time `json:"resolvedAt" db:"resolved_at"`
+}
+
+// Resolved returns whether all the nodes follow the same chain again
+func (c *ChainSplit) Resolved() bool {
+	return c.ResolvedAt != nil
+}
+
+func (c *ChainSplit) Copy() *ChainSplit {
+	cc := new(ChainSplit)
+	*cc = *c
+
+	cc.Branches = splitBranches{}
+	for hash, nodes := range c.Branches {
+		cc.Branches[hash] = append([]string{}, nodes...)
+	}
+	if c.ResolvedAt != nil {
+		resolvedAt := *c.ResolvedAt
+		cc.ResolvedAt = &resolvedAt
+	}
+	return cc
+}
+
+// nodes returns all the nodes involved in the split
+func (c *ChainSplit) nodes() []string {
+	nodes := []string{}
+	for _, branch := range c.Branches {
+		nodes = append(nodes, branch...)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// splitBranches is the set of nodes in each branch of a split, stored as json
+type splitBranches map[string][]string
+
+func (s splitBranches) Value() (driver.Value, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (s *splitBranches) Scan(value interface{}) error {
+	var str sql.NullString
+	if err := str.Scan(value); err != nil {
+		return err
+	}
+	*s = splitBranches{}
+	if str.String == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(str.String), s)
+}
+
+// partition returns a signature of how the nodes are grouped in the branches,
+// independent of the hashes of the branches
+func (s splitBranches) partition() string {
+	groups := []string{}
+	for _, nodes := range s {
+		nodes = append([]string{}, nodes...)
+		sort.Strings(nodes)
+		groups = append(groups, strings.Join(nodes, ","))
+	}
+	sort.Strings(groups)
+	return strings.Join(groups, "|")
+}
+
+// nodeChain are the latest blocks (number to hash) followed by a node
+type nodeChain struct {
+	head   int
+	hashes map[int]string
+}
+
+func (n *nodeChain) set(stub BlockStub, depth int) {
+	// any block above the new one belongs to a chain the node does not follow anymore
+	for num := range n.hashes {
+		if num > stub.Number {
+			delete(n.hashes, num)
+		}
+	}
+	n.hashes[stub.Number] = stub.Hash
+	if stub.ParentHash != "" && stub.Number > 0 {
+		if prev, ok := n.hashes[stub.Number-1]; ok && prev != stub.ParentHash {
+			// the node switched chains and its older blocks are unknown now
+			for num := range n.hashes {
+				if num < stub.Number-1 {
+					delete(n.hashes, num)
+				}
+			}
+		}
+		n.hashes[stub.Number-1] = stub.ParentHash
+	}
+	n.head = stub.Number
+
+	for num := range n.hashes {
+		if num <= stub.Number-depth {
+			delete(n.hashes, num)
+		}
+	}
+}
+
+func (n *nodeChain) remove(stub BlockStub) {
+	if n.hashes[stub.Number] == stub.Hash {
+		delete(n.hashes, stub.Number)
+	}
+}
+
+// chainView tracks the recent chain of each node from the 'block' and 'headEvent'
+// messages to detect when the nodes follow different chains at the same height.
+type chainView struct {
+	lock sync.Mutex
+
+	depth  int
+	nodes  map[string]*nodeChain
+	active []*ChainSplit
+}
+
+func newChainView(depth int) *chainView {
+	if depth <= 0 {
+		depth = defaultChainViewDepth
+	}
+	return &chainView{
+		depth: depth,
+		nodes: map[string]*nodeChain{},
+	}
+}
+
+func (c *chainView) node(nodeID string) *nodeChain {
+	n, ok := c.nodes[nodeID]
+	if !ok {
+		n = &nodeChain{hashes: map[int]string{}}
+		c.nodes[nodeID] = n
+	}
+	return n
+}
+
+// addBlock sets the block as the head of the node and returns the splits that
+// have been detected, changed or resolved with the update
+func (c *chainView) addBlock(nodeID string, block *Block) []*ChainSplit {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stub := BlockStub{Number: block.Number, Hash: block.Hash, ParentHash: block.ParentHash}
+	c.node(nodeID).set(stub, c.depth)
+
+	return c.check([]int{block.Number})
+}
+
+// applyHeadEvent updates the chain of the node with the blocks removed and added by
+// the event and returns the splits that have been detected, changed or resolved
+func (c *chainView) applyHeadEvent(nodeID string, evnt *HeadEvent) []*ChainSplit {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := c.node(nodeID)
+	for _, stub := range evnt.Removed {
+		n.remove(stub)
+	}
+
+	heights := []int{}
+	for _, stub := range sortedStubs(evnt.Added) {
+		n.set(stub, c.depth)
+		heights = append(heights, stub.Number)
+	}
+	return c.check(heights)
+}
+
+// removeNode stops tracking the chain of the node
+func (c *chainView) removeNode(nodeID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.nodes, nodeID)
+}
+
+// activeSplits returns the splits that have not been resolved yet
+func (c *chainView) activeSplits() []*ChainSplit {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	res := []*ChainSplit{}
+	for _, split := range c.active {
+		res = append(res, split.Copy())
+	}
+	return res
+}
+
+func (c *chainView) check(heights []int) []*ChainSplit {
+	changed := []*ChainSplit{}
+
+	for _, height := range heights {
+		branches := c.branchesAt(height)
+		if len(branches) < 2 {
+			continue
+		}
+
+		forkNumber, forkHash := c.forkPoint(branches, height)
+		partition := branches.partition()
+
+		var split *ChainSplit
+		for _, s := range c.active {
+			if (forkHash != "" && s.ForkHash == forkHash) || s.Branches.partition() == partition {
+				split = s
+				break
+			}
+		}
+
+		if split == nil {
+			// new split
+			id, err := newUlid()
+			if err != nil {
+				continue
+			}
+			split = &ChainSplit{
+				ID:         id,
+				Height:     height,
+				ForkNumber: forkNumber,
+				ForkHash:   forkHash,
+				Branches:   branches,
+				DetectedAt: time.Now().UTC(),
+			}
+			c.active = append(c.active, split)
+			changed = append(changed, split.Copy())
+			continue
+		}
+
+		if split.Branches.partition() != partition {
+			// the nodes moved between the branches, keep the latest view
+			split.Branches = branches
+			changed = append(changed, split.Copy())
+		}
+	}
+
+	changed = append(changed, c.resolve()...)
+	return changed
+}
+
+// branchesAt groups the nodes by their block hash at the given height
+func (c *chainView) branchesAt(height int) splitBranches {
+	branches := splitBranches{}
+	for nodeID, n := range c.nodes {
+		if hash, ok := n.hashes[height]; ok {
+			branches[hash] = append(branches[hash], nodeID)
+		}
+	}
+	for _, nodes := range branches {
+		sort.Strings(nodes)
+	}
+	return branches
+}
+
+// forkPoint returns the highest block below height shared by all the nodes in the branches
+func (c *chainView) forkPoint(branches splitBranches, height int) (int, string) {
+	nodes := []*nodeChain{}
+	for _, branch := range branches {
+		for _, nodeID := range branch {
+			nodes = append(nodes, c.nodes[nodeID])
+		}
+	}
+
+	for num := height - 1; num >= 0 && num > height-c.depth; num-- {
+		hash, ok := nodes[0].hashes[num]
+		if !ok {
+			return -1, ""
+		}
+		shared := true
+		for _, n := range nodes[1:] {
+			other, ok := n.hashes[num]
+			if !ok {
+				return -1, ""
+			}
+			if other != hash {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			return num, hash
+		}
+	}
+	return -1, ""
+}
+
+// resolve removes and returns the active splits whose nodes agree again. The splits
+// too old to be tracked are resolved too, even if the nodes have not converged.
+func (c *chainView) resolve() []*ChainSplit {
+	resolved := []*ChainSplit{}
+
+	// highest head among the nodes to expire the splits that are too old to track
+	head := 0
+	for _, n := range c.nodes {
+		if n.head > head {
+			head = n.head
+		}
+	}
+
+	now := time.Now().UTC()
+
+	active := []*ChainSplit{}
+	for _, split := range c.active {
+		if split.Height <= head-c.depth {
+			split.ResolvedAt = &now
+			resolved = append(resolved, split.Copy())
+			continue
+		}
+
+		hashes := map[string]struct{}{}
+		for _, nodeID := range split.nodes() {
+			n, ok := c.nodes[nodeID]
+			if !ok {
+				continue
+			}
+			if hash, ok := n.hashes[split.Height]; ok {
+				hashes[hash] = struct{}{}
+			}
+		}
+		if len(hashes) < 2 {
+			split.ResolvedAt = &now
+			resolved = append(resolved, split.Copy())
+			continue
+		}
+		active = append(active, split)
+	}
+	c.active = active
+	return resolved
+}
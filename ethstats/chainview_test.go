@@ -0,0 +1,102 @@
+package ethstats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainView_Split(t *testing.T) {
+	c := newChainView(0)
+
+	// both nodes share the first block
+	assert.Empty(t, c.addBlock("a", &Block{Number: 1, Hash: "0x1", ParentHash: "0x0"}))
+	assert.Empty(t, c.addBlock("b", &Block{Number: 1, Hash: "0x1", ParentHash: "0x0"}))
+
+	// and diverge on the second
+	assert.Empty(t, c.addBlock("a", &Block{Number: 2, Hash: "0x2a", ParentHash: "0x1"}))
+	splits := c.addBlock("b", &Block{Number: 2, Hash: "0x2b", ParentHash: "0x1"})
+	assert.Len(t, splits, 1)
+
+	split := splits[0]
+	assert.False(t, split.Resolved())
+	assert.Equal(t, split.Height, 2)
+	assert.Equal(t, split.ForkNumber, 1)
+	assert.Equal(t, split.ForkHash, "0x1")
+	assert.Equal(t, split.Branches, splitBranches{"0x2a": {"a"}, "0x2b": {"b"}})
+
+	// the split continues on the next blocks without new events
+	assert.Empty(t, c.addBlock("a", &Block{Number: 3, Hash: "0x3a", ParentHash: "0x2a"}))
+	assert.Empty(t, c.addBlock("b", &Block{Number: 3, Hash: "0x3b", ParentHash: "0x2b"}))
+	assert.Len(t, c.activeSplits(), 1)
+
+	// b reorgs to the chain of a
+	splits = c.addBlock("b", &Block{Number: 4, Hash: "0x4a", ParentHash: "0x3a"})
+	assert.Len(t, splits, 1)
+	assert.Equal(t, splits[0].ID, split.ID)
+	assert.True(t, splits[0].Resolved())
+	assert.Len(t, c.activeSplits(), 0)
+}
+
+func TestChainView_HeadEvent(t *testing.T) {
+	c := newChainView(0)
+
+	c.addBlock("a", &Block{Number: 1, Hash: "0x1", ParentHash: "0x0"})
+	c.addBlock("a", &Block{Number: 2, Hash: "0x2a", ParentHash: "0x1"})
+	c.addBlock("b", &Block{Number: 1, Hash: "0x1", ParentHash: "0x0"})
+
+	splits := c.applyHeadEvent("b", &HeadEvent{
+		Added: []BlockStub{{Number: 2, Hash: "0x2b", ParentHash: "0x1"}},
+	})
+	assert.Len(t, splits, 1)
+	assert.Equal(t, splits[0].ForkHash, "0x1")
+
+	// b reorgs to the block of a
+	splits = c.applyHeadEvent("b", &HeadEvent{
+		Added:   []BlockStub{{Number: 2, Hash: "0x2a", ParentHash: "0x1"}},
+		Removed: []BlockStub{{Number: 2, Hash: "0x2b", ParentHash: "0x1"}},
+	})
+	assert.Len(t, splits, 1)
+	assert.True(t, splits[0].Resolved())
+}
+
+func TestChainView_UnknownForkPoint(t *testing.T) {
+	c := newChainView(0)
+
+	c.addBlock("a", &Block{Number: 10, Hash: "0x10a"})
+	splits := c.addBlock("b", &Block{Number: 10, Hash: "0x10b"})
+	assert.Len(t, splits, 1)
+	assert.Equal(t, splits[0].ForkNumber, -1)
+	assert.Equal(t, splits[0].ForkHash, "")
+}
+
+func TestChainView_Depth(t *testing.T) {
+	c := newChainView(4)
+
+	c.addBlock("a", &Block{Number: 1, Hash: "0x1a"})
+	c.addBlock("b", &Block{Number: 1, Hash: "0x1b"})
+	assert.Len(t, c.activeSplits(), 1)
+
+	// the split is too old to be tracked once the nodes move past the depth
+	c.addBlock("a", &Block{Number: 10, Hash: "0x10"})
+	assert.Len(t, c.activeSplits(), 0)
+}
+
+func TestChainView_Expired(t *testing.T) {
+	c := newChainView(4)
+
+	c.addBlock("a", &Block{Number: 1, Hash: "0x1a"})
+	splits := c.addBlock("b", &Block{Number: 1, Hash: "0x1b"})
+	assert.Len(t, splits, 1)
+
+	// a moves past the depth while b is still on its own fork
+	assert.Empty(t, c.addBlock("a", &Block{Number: 4, Hash: "0x4a"}))
+	expired := c.addBlock("a", &Block{Number: 5, Hash: "0x5a"})
+	assert.Len(t, expired, 1)
+	assert.Equal(t, expired[0].ID, splits[0].ID)
+	assert.True(t, expired[0].Resolved())
+	assert.Empty(t, c.activeSplits())
+
+	// the split is reported only once
+	assert.Empty(t, c.addBlock("a", &Block{Number: 6, Hash: "0x6a"}))
+}
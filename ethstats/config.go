@@ -0,0 +1,325 @@
+package ethstats
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigOption is an option of the config file with its values
+type ConfigOption struct {
+	// Name is the name of the option with the nested keys joined by dots (i.e. collector.secret)
+	Name string
+
+	// Values has one value for a scalar and one value for each item of a list
+	Values []string
+}
+
+// ReadConfigFile reads the options of a yaml (or json) config file, or of a toml
+// config file if its extension is .toml. The options are named like the flags of
+// the server, either nested or with dots in the keys:
+//
+//	collector:
+//	  addr: 0.0.0.0:8000
+//	retention.interval: 1h
+//
+// The items of a list that are maps (i.e. the frontends) are converted to comma
+// separated key=value pairs, with the lists inside the items separated by '|'.
+func ReadConfigFile(path string) ([]ConfigOption, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *yaml.Node
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		var doc map[string]interface{}
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode config file %s: %v", path, err)
+		}
+		if len(doc) == 0 {
+			// empty file
+			return nil, nil
+		}
+		root = tomlNode(doc)
+	} else {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode config file %s: %v", path, err)
+		}
+		if len(doc.Content) == 0 {
+			// empty file
+			return nil, nil
+		}
+		root = doc.Content[0]
+	}
+
+	options := []ConfigOption{}
+	if err := flattenConfig(&options, "", root); err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	names := map[string]struct{}{}
+	for _, option := range options {
+		if _, ok := names[option.Name]; ok {
+			return nil, fmt.Errorf("config file %s: option '%s' is duplicated", path, option.Name)
+		}
+		names[option.Name] = struct{}{}
+	}
+	return options, nil
+}
+
+func flattenConfig(options *[]ConfigOption, prefix string, node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			if err := flattenConfig(options, name, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+
+	case yaml.SequenceNode:
+		if prefix == "" {
+			return fmt.Errorf("expected a map of options")
+		}
+		values := []string{}
+		for _, item := range node.Content {
+			value, err := configItem(prefix, item)
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		*options = append(*options, ConfigOption{Name: prefix, Values: values})
+
+	case yaml.ScalarNode:
+		if prefix == "" {
+			return fmt.Errorf("expected a map of options")
+		}
+		*options = append(*options, ConfigOption{Name: prefix, Values: []string{node.Value}})
+
+	default:
+		return fmt.Errorf("option '%s' is not valid", prefix)
+	}
+	return nil
+}
+
+// tomlNode converts a value decoded from a toml file to a yaml node, so
+// the options of both formats are flattened the same way. The keys of
+// the tables are sorted since the decoded maps are not ordered.
+func tomlNode(val interface{}) *yaml.Node {
+	switch obj := val.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range keys {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, tomlNode(obj[key]))
+		}
+		return node
+
+	case []map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range obj {
+			node.Content = append(node.Content, tomlNode(item))
+		}
+		return node
+
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range obj {
+			node.Content = append(node.Content, tomlNode(item))
+		}
+		return node
+
+	case time.Time:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: obj.Format(time.RFC3339)}
+
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(obj)}
+	}
+}
+
+// configItem returns the value of an item of a list
+func configItem(name string, item *yaml.Node) (string, error) {
+	if item.Kind == yaml.AliasNode {
+		item = item.Alias
+	}
+
+	switch item.Kind {
+	case yaml.ScalarNode:
+		return item.Value, nil
+
+	case yaml.MappingNode:
+		pairs := []string{}
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			key, val := item.Content[i].Value, item.Content[i+1]
+
+			var value string
+			switch val.Kind {
+			case yaml.ScalarNode:
+				value = val.Value
+
+			case yaml.SequenceNode:
+				values := []string{}
+				for _, v := range val.Content {
+					if v.Kind != yaml.ScalarNode {
+						return "", fmt.Errorf("option '%s.%s' is not valid", name, key)
+					}
+					values = append(values, v.Value)
+				}
+				value = strings.Join(values, "|")
+
+			default:
+				return "", fmt.Errorf("option '%s.%s' is not valid", name, key)
+			}
+			pairs = append(pairs, key+"="+value)
+		}
+		return strings.Join(pairs, ","), nil
+
+	default:
+		return "", fmt.Errorf("option '%s' is not valid", name)
+	}
+}
+
+// Validate returns an error if the config is not valid. The errors refer to the
+// options by the names of their flags, which are also the keys of the config file.
+func (c *Config) Validate() error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("'db-endpoint' is not set")
+	}
+	if c.CollectorAddr == "" {
+		return fmt.Errorf("'collector.addr' is not set")
+	}
+
+	durations := []struct {
+		name string
+		val  time.Duration
+	}{
+		{"collector.credentials-reload", c.CredentialsReloadInterval},
+		{"collector.idle-timeout", c.SessionIdleTimeout},
+		{"history.interval", c.HistoryInterval},
+		{"retention.interval", c.RetentionInterval},
+		{"retention.blocks", c.Retention.Blocks},
+		{"retention.block-txs", c.Retention.BlockTxs},
+		{"retention.headevents", c.Retention.HeadEvents},
+		{"retention.nodestats-history", c.Retention.NodeStatsHistory},
+		{"alerts.interval", c.Alerts.Interval},
+		{"alerts.no-block", c.Alerts.Rules.NoBlock},
+		{"alerts.syncing-for", c.Alerts.Rules.SyncingFor},
+		{"alerts.offline-for", c.Alerts.Rules.OfflineFor},
+		{"shutdown.timeout", c.ShutdownTimeout},
+	}
+	for _, d := range durations {
+		if d.val < 0 {
+			return fmt.Errorf("'%s' cannot be negative", d.name)
+		}
+	}
+
+	numbers := []struct {
+		name string
+		val  int
+	}{
+		{"chain.depth", c.ChainViewDepth},
+		{"chain.finality-depth", c.FinalityDepth},
+		{"history.lookback", c.HistoryLookback},
+		{"retention.batch-size", c.RetentionBatchSize},
+		{"alerts.blocks-behind", c.Alerts.Rules.BlocksBehind},
+		{"alerts.min-peers", c.Alerts.Rules.MinPeers},
+		{"alerts.reorg-depth", c.Alerts.Rules.ReorgDepth},
+	}
+	for _, n := range numbers {
+		if n.val < 0 {
+			return fmt.Errorf("'%s' cannot be negative", n.name)
+		}
+	}
+
+	if c.HistoryInterval > 0 && c.HistoryLookback == 0 {
+		return fmt.Errorf("'history.lookback' must be greater than 0 to backfill the history")
+	}
+	if c.HistoryInterval > 0 && c.Retention.Blocks > 0 && time.Duration(c.HistoryLookback)*maxBlockTime >= c.Retention.Blocks {
+		return fmt.Errorf("'history.lookback' of %d blocks may reach the blocks deleted by 'retention.blocks' (%s at %s per block)",
+			c.HistoryLookback, c.Retention.Blocks, maxBlockTime)
+	}
+	if c.RetentionInterval > 0 && c.RetentionBatchSize == 0 {
+		return fmt.Errorf("'retention.batch-size' must be greater than 0 to delete the old data")
+	}
+	if c.Alerts.Rules.enabled() && c.Alerts.Interval == 0 {
+		return fmt.Errorf("'alerts.interval' must be greater than 0 to evaluate the alert rules")
+	}
+	for _, webhook := range c.Alerts.Webhooks {
+		if webhook.URL == "" {
+			return fmt.Errorf("'alerts.webhook': url not set")
+		}
+	}
+
+	if _, err := c.frontends(); err != nil {
+		return fmt.Errorf("'frontend': %v", err)
+	}
+	return nil
+}
+
+// restartOptions returns the names of the options that differ in the other
+// config and are not applied when the config is reloaded, only on restart
+func (c *Config) restartOptions(other *Config) []string {
+	webhooksChanged := len(c.Alerts.Webhooks) != len(other.Alerts.Webhooks)
+	for i := 0; !webhooksChanged && i < len(c.Alerts.Webhooks); i++ {
+		webhooksChanged = *c.Alerts.Webhooks[i] != *other.Alerts.Webhooks[i]
+	}
+
+	options := []struct {
+		name    string
+		changed bool
+	}{
+		{"db-endpoint", c.Endpoint != other.Endpoint},
+		{"collector.addr", c.CollectorAddr != other.CollectorAddr},
+		{"collector.credentials-reload", c.CredentialsReloadInterval != other.CredentialsReloadInterval},
+		{"collector.idle-timeout", c.SessionIdleTimeout != other.SessionIdleTimeout},
+		{"save-block-txs", c.ShouldSaveBlockTxs != other.ShouldSaveBlockTxs},
+		{"chain.depth", c.ChainViewDepth != other.ChainViewDepth},
+		{"chain.finality-depth", c.FinalityDepth != other.FinalityDepth},
+		{"history.interval", c.HistoryInterval != other.HistoryInterval},
+		{"history.lookback", c.HistoryLookback != other.HistoryLookback},
+		{"retention.interval", c.RetentionInterval != other.RetentionInterval},
+		{"retention.batch-size", c.RetentionBatchSize != other.RetentionBatchSize},
+		{"retention.blocks", c.Retention.Blocks != other.Retention.Blocks},
+		{"retention.block-txs", c.Retention.BlockTxs != other.Retention.BlockTxs},
+		{"retention.headevents", c.Retention.HeadEvents != other.Retention.HeadEvents},
+		{"retention.nodestats-history", c.Retention.NodeStatsHistory != other.Retention.NodeStatsHistory},
+		{"alerts.interval", c.Alerts.Interval != other.Alerts.Interval},
+		{"alerts.no-block", c.Alerts.Rules.NoBlock != other.Alerts.Rules.NoBlock},
+		{"alerts.blocks-behind", c.Alerts.Rules.BlocksBehind != other.Alerts.Rules.BlocksBehind},
+		{"alerts.min-peers", c.Alerts.Rules.MinPeers != other.Alerts.Rules.MinPeers},
+		{"alerts.syncing-for", c.Alerts.Rules.SyncingFor != other.Alerts.Rules.SyncingFor},
+		{"alerts.reorg-depth", c.Alerts.Rules.ReorgDepth != other.Alerts.Rules.ReorgDepth},
+		{"alerts.offline-for", c.Alerts.Rules.OfflineFor != other.Alerts.Rules.OfflineFor},
+		{"alerts.webhook", webhooksChanged},
+		{"shutdown.timeout", c.ShutdownTimeout != other.ShutdownTimeout},
+	}
+
+	changed := []string{}
+	for _, o := range options {
+		if o.changed {
+			changed = append(changed, o.name)
+		}
+	}
+	return changed
+}
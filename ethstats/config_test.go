@@ -0,0 +1,280 @@
+package ethstats
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+db-endpoint: memory://
+collector:
+  addr: 0.0.0.0:8000
+  idle-timeout: 2m
+  allow: [a, b]
+retention.interval: 1h
+frontend:
+  - name: public
+    addr: ws://localhost:3000/api
+    types: [block, stats]
+  - name=internal,addr=ws://localhost:3001/api
+`)
+	options, err := ReadConfigFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, options, []ConfigOption{
+		{Name: "db-endpoint", Values: []string{"memory://"}},
+		{Name: "collector.addr", Values: []string{"0.0.0.0:8000"}},
+		{Name: "collector.idle-timeout", Values: []string{"2m"}},
+		{Name: "collector.allow", Values: []string{"a", "b"}},
+		{Name: "retention.interval", Values: []string{"1h"}},
+		{Name: "frontend", Values: []string{
+			"name=public,addr=ws://localhost:3000/api,types=block|stats",
+			"name=internal,addr=ws://localhost:3001/api",
+		}},
+	})
+
+	options, err = ReadConfigFile(writeConfigFile(t, ""))
+	assert.NoError(t, err)
+	assert.Empty(t, options)
+
+	for _, content := range []string{
+		"collector: [",
+		"- a\n- b",
+		"collector:\n  addr: a\ncollector.addr: b",
+		"frontend:\n  - name: public\n    addr: {host: localhost}",
+	} {
+		_, err := ReadConfigFile(writeConfigFile(t, content))
+		assert.Error(t, err, content)
+	}
+
+	_, err = ReadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestReadConfigFile_TOML(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	options, err := ReadConfigFile(write(`
+db-endpoint = "memory://"
+retention.interval = "1h"
+retention.batch-size = 500
+
+[collector]
+addr = "0.0.0.0:8000"
+allow = ["a", "b"]
+
+[[frontend]]
+name = "public"
+addr = "ws://localhost:3000/api"
+types = ["block", "stats"]
+
+[[frontend]]
+name = "internal"
+addr = "ws://localhost:3001/api"
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, options, []ConfigOption{
+		{Name: "collector.addr", Values: []string{"0.0.0.0:8000"}},
+		{Name: "collector.allow", Values: []string{"a", "b"}},
+		{Name: "db-endpoint", Values: []string{"memory://"}},
+		{Name: "frontend", Values: []string{
+			"addr=ws://localhost:3000/api,name=public,types=block|stats",
+			"addr=ws://localhost:3001/api,name=internal",
+		}},
+		{Name: "retention.batch-size", Values: []string{"500"}},
+		{Name: "retention.interval", Values: []string{"1h"}},
+	})
+
+	options, err = ReadConfigFile(write(""))
+	assert.NoError(t, err)
+	assert.Empty(t, options)
+
+	for _, content := range []string{
+		"collector = [",
+		"collector.addr = \"a\"\n[collector]\naddr = \"b\"",
+		"[[frontend]]\nname = \"public\"\naddr = {host = \"localhost\"}",
+	} {
+		_, err := ReadConfigFile(write(content))
+		assert.Error(t, err, content)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	newConfig := func() *Config {
+		return &Config{
+			Endpoint:           "memory://",
+			CollectorAddr:      "0.0.0.0:8000",
+			HistoryInterval:    time.Minute,
+			HistoryLookback:    1000,
+			RetentionInterval:  time.Hour,
+			RetentionBatchSize: 1000,
+		}
+	}
+	assert.NoError(t, newConfig().Validate())
+
+	// the lookback is within the retention of the blocks
+	config := newConfig()
+	config.Retention.Blocks = 24 * time.Hour
+	assert.NoError(t, config.Validate())
+
+	cases := []func(c *Config){
+		func(c *Config) { c.Endpoint = "" },
+		func(c *Config) { c.CollectorAddr = "" },
+		func(c *Config) { c.SessionIdleTimeout = -time.Second },
+		func(c *Config) { c.Retention.Blocks = -time.Hour },
+		func(c *Config) { c.ChainViewDepth = -1 },
+		func(c *Config) { c.HistoryLookback = 0 },
+		func(c *Config) { c.Retention.Blocks = time.Hour },
+		func(c *Config) { c.RetentionBatchSize = 0 },
+		func(c *Config) { c.Alerts.Rules.MinPeers = 3 },
+		func(c *Config) { c.Alerts.Webhooks = []*AlertWebhook{{Format: WebhookJSON}} },
+		func(c *Config) { c.Frontends = []*Frontend{{Name: "public"}} },
+	}
+	for i, fn := range cases {
+		config := newConfig()
+		fn(config)
+		assert.Error(t, config.Validate(), "case %d", i)
+	}
+}
+
+func TestConfig_RestartOptions(t *testing.T) {
+	newConfig := func() *Config {
+		return &Config{
+			Endpoint:      "memory://",
+			CollectorAddr: "0.0.0.0:8000",
+			Alerts: AlertConfig{
+				Webhooks: []*AlertWebhook{{URL: "http://localhost/hook", Format: WebhookJSON}},
+			},
+		}
+	}
+	assert.Empty(t, newConfig().restartOptions(newConfig()))
+
+	// the options applied on reload are not reported
+	other := newConfig()
+	other.AdminToken = "token"
+	other.Frontends = []*Frontend{{Name: "public", Addr: "ws://localhost:3000/api"}}
+	assert.Empty(t, newConfig().restartOptions(other))
+
+	other = newConfig()
+	other.CollectorAddr = "0.0.0.0:9000"
+	other.Retention.Blocks = time.Hour
+	other.ChainViewDepth = 64
+	other.Alerts.Rules.MinPeers = 3
+	other.Alerts.Webhooks[0].Format = WebhookSlack
+	assert.Equal(t, newConfig().restartOptions(other), []string{
+		"collector.addr",
+		"chain.depth",
+		"retention.blocks",
+		"alerts.min-peers",
+		"alerts.webhook",
+	})
+}
+
+func TestServer_Reload(t *testing.T) {
+	public := &wsChHandler{recvCh: make(chan []byte, 10)}
+	publicSrv := newMockWsServer(t, "", public.handle)
+	defer publicSrv.close()
+
+	internal := &wsChHandler{recvCh: make(chan []byte, 10)}
+	internalSrv := newMockWsServer(t, "", internal.handle)
+	defer internalSrv.close()
+
+	config := &Config{
+		Endpoint:      "memory://",
+		CollectorAddr: "0.0.0.0:8000",
+		Frontends:     []*Frontend{{Name: "public", Addr: publicSrv.addr}},
+	}
+	srv := newServer(hclog.NewNullLogger(), config, NewMemoryState())
+
+	frontends, err := config.frontends()
+	assert.NoError(t, err)
+	srv.auth, err = newNodeAuth("", "", nil, nil)
+	assert.NoError(t, err)
+	srv.collector = &wsCollector{
+		manager:   srv,
+		logger:    hclog.NewNullLogger(),
+		frontends: frontends,
+		auth:      srv.auth,
+	}
+	collector := newMockWsServer(t, "", func(ctx context.Context, conn *websocket.Conn) {
+		srv.collector.handle(conn)
+	})
+	defer collector.close()
+
+	recv := func(ch chan []byte) *Msg {
+		select {
+		case raw := <-ch:
+			msg, err := DecodeMsg(raw)
+			assert.NoError(t, err)
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+		return nil
+	}
+
+	login := func(name string) *mockWsClient {
+		clt := newMockWsClient(t, collector.addr)
+		clt.emit("hello", `{"secret": "", "info": {"name": "`+name+`"}}`)
+		_, _, err := clt.conn.ReadMessage()
+		assert.NoError(t, err)
+		return clt
+	}
+	a := login("a")
+	defer a.close()
+	b := login("b")
+	defer b.close()
+
+	assert.Equal(t, recv(public.recvCh).typ, "hello")
+	assert.Equal(t, recv(public.recvCh).typ, "hello")
+
+	// the public frontend is replaced by the internal one and b is denied
+	err = srv.Reload(&Config{
+		Endpoint:      "memory://",
+		CollectorAddr: "0.0.0.0:8000",
+		AdminToken:    "token",
+		DenyNodes:     []string{"b"},
+		Frontends:     []*Frontend{{Name: "internal", Addr: internalSrv.addr, Secret: "internal"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, srv.config.AdminToken, "token")
+
+	hello := recv(internal.recvCh)
+	assert.Equal(t, hello.typ, "hello")
+
+	var secret string
+	assert.NoError(t, hello.decodeMsg("secret", &secret))
+	assert.Equal(t, secret, "internal")
+
+	// the session of a is kept and proxied to the new frontend
+	a.emit("stats", `{}`)
+	assert.Equal(t, recv(internal.recvCh).typ, "stats")
+
+	_, _, err = b.conn.ReadMessage()
+	assert.Error(t, err)
+
+	proxies := srv.sessions["a"].getProxies()
+	assert.Len(t, proxies, 1)
+	assert.Equal(t, proxies[0].frontend, "internal")
+
+	// an invalid config is not applied
+	assert.Error(t, srv.Reload(&Config{Endpoint: "memory://"}))
+	assert.Equal(t, srv.config.AdminToken, "token")
+}
@@ -0,0 +1,132 @@
+package ethstats
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	ControlHistory    = "history"
+	ControlStats      = "stats"
+	ControlDisconnect = "disconnect"
+)
+
+// nodeSession returns the session of the connected node
+func (s *Server) nodeSession(nodeID string) (*wsSession, error) {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	session, ok := s.sessions[nodeID]
+	if !ok {
+		return nil, errNotFound("node '%s' not connected", nodeID)
+	}
+	return session, nil
+}
+
+// RequestHistory asks the node to send the blocks between from and to (inclusive).
+// The blocks are written once the node replies with a history message.
+func (s *Server) RequestHistory(nodeID string, from, to int) error {
+	if from < 0 || to < from {
+		return errBadRequest("invalid range %d-%d", from, to)
+	}
+	if to-from+1 > maxHistoryRequest {
+		return errBadRequest("at most %d blocks can be requested at once", maxHistoryRequest)
+	}
+	session, err := s.nodeSession(nodeID)
+	if err != nil {
+		return err
+	}
+
+	numbers := []int{}
+	for num := from; num <= to; num++ {
+		numbers = append(numbers, num)
+	}
+	msg, err := historyRequest(numbers)
+	if err != nil {
+		return err
+	}
+	if err := session.send(msg); err != nil {
+		return err
+	}
+	s.logger.Info("history requested", "node", nodeID, "from", from, "to", to, "blocks", len(numbers))
+	return nil
+}
+
+// RequestStats asks the node to report its stats now. The ethstats clients (i.e. geth
+// and bor) only report their stats periodically and do not handle a stats request,
+// so nothing is sent to the node and an error is returned instead.
+func (s *Server) RequestStats(nodeID string) error {
+	if _, err := s.nodeSession(nodeID); err != nil {
+		return err
+	}
+	return errNotImplemented("stats requests are not supported by the client of node '%s'", nodeID)
+}
+
+// DisconnectNode closes the session of the node
+func (s *Server) DisconnectNode(nodeID string) error {
+	session, err := s.nodeSession(nodeID)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("disconnecting node", "node", nodeID)
+	session.close(SessionReasonAdmin)
+	return nil
+}
+
+// checkAdminToken returns an error if the request is not authenticated with the admin
+// token as a bearer token. If the token is not required, the request is accepted while
+// there is no admin token, otherwise it is always rejected without one.
+func (s *Server) checkAdminToken(r *http.Request, required bool) error {
+	s.configLock.RLock()
+	adminToken := s.config.AdminToken
+	s.configLock.RUnlock()
+
+	if adminToken == "" && !required {
+		return nil
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if adminToken == "" || !secretsEqual(token, adminToken) {
+		return errUnauthorized("admin token is not correct")
+	}
+	return nil
+}
+
+// handleNodeControl sends a control request to a connected node. The
+// requests have to be authenticated with the admin token as a bearer token.
+func (s *Server) handleNodeControl(r *http.Request) (interface{}, error) {
+	if err := s.checkAdminToken(r, true); err != nil {
+		return nil, err
+	}
+
+	params := pathParams(r, "/api/v1/admin/nodes/")
+	if len(params) != 2 {
+		return nil, errNotFound("path %s not found", r.URL.Path)
+	}
+	nodeID, request := params[0], params[1]
+
+	var err error
+	switch request {
+	case ControlHistory:
+		var from, to int
+		if from, err = queryInt(r, "from", -1); err != nil {
+			return nil, err
+		}
+		if to, err = queryInt(r, "to", from); err != nil {
+			return nil, err
+		}
+		err = s.RequestHistory(nodeID, from, to)
+
+	case ControlStats:
+		err = s.RequestStats(nodeID)
+
+	case ControlDisconnect:
+		err = s.DisconnectNode(nodeID)
+
+	default:
+		return nil, errNotFound("request '%s' not found", request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"node": nodeID, "request": request}, nil
+}
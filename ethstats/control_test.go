@@ -0,0 +1,99 @@
+package ethstats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_RequestHistory(t *testing.T) {
+	srv := newTestServer(t)
+
+	writer := &mockWsWriter{}
+	assert.NoError(t, srv.sessionStarted(newWsSession("a", writer)))
+
+	assert.NoError(t, srv.RequestHistory("a", 1, 3))
+	assert.Len(t, writer.msgs, 1)
+	assert.Equal(t, writer.msgs[0].typ, "history")
+
+	var list []int
+	assert.NoError(t, writer.msgs[0].decodeMsg("list", &list))
+	assert.Equal(t, list, []int{1, 2, 3})
+
+	assert.Error(t, srv.RequestHistory("a", 3, 1))
+	assert.Error(t, srv.RequestHistory("a", 1, maxHistoryRequest+1))
+	assert.Error(t, srv.RequestHistory("b", 1, 3))
+
+	// the reply of the node is handled as any other message
+	srv.handleMessage("a", mustDecodeMsg(t, "history", `{"history": [{"number": 2, "hash": "0x2"}]}`))
+
+	block, err := srv.state.GetBlock("0x2")
+	assert.NoError(t, err)
+	assert.NotNil(t, block)
+}
+
+func TestServer_RequestStats(t *testing.T) {
+	srv := newTestServer(t)
+
+	writer := &mockWsWriter{}
+	assert.NoError(t, srv.sessionStarted(newWsSession("a", writer)))
+
+	// the clients do not handle the request, so it is not sent
+	err := srv.RequestStats("a")
+	assert.Error(t, err)
+	assert.Equal(t, err.(*apiError).status, http.StatusNotImplemented)
+	assert.Len(t, writer.msgs, 0)
+
+	err = srv.RequestStats("b")
+	assert.Error(t, err)
+	assert.Equal(t, err.(*apiError).status, http.StatusNotFound)
+}
+
+func TestServer_DisconnectNode(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn := &mockWsConn{}
+	session := newWsSession("a", conn)
+	assert.NoError(t, srv.sessionStarted(session))
+
+	assert.NoError(t, srv.DisconnectNode("a"))
+	assert.True(t, conn.closed)
+	assert.Equal(t, session.closeReason(), SessionReasonAdmin)
+
+	assert.Error(t, srv.DisconnectNode("b"))
+}
+
+func TestServer_NodeControl(t *testing.T) {
+	srv := newTestServer(t)
+	srv.config.AdminToken = "token"
+
+	writer := &mockWsWriter{}
+	assert.NoError(t, srv.sessionStarted(newWsSession("a", writer)))
+
+	api := &apiHandler{logger: srv.logger, state: srv.state}
+	handler := api.post(srv.handleNodeControl)
+
+	request := func(method, path, token string) int {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, request(http.MethodPost, "/api/v1/admin/nodes/a/stats", ""), http.StatusUnauthorized)
+	assert.Equal(t, request(http.MethodPost, "/api/v1/admin/nodes/a/stats", "other"), http.StatusUnauthorized)
+	assert.Equal(t, request(http.MethodGet, "/api/v1/admin/nodes/a/stats", "token"), http.StatusMethodNotAllowed)
+
+	assert.Equal(t, request(http.MethodPost, "/api/v1/admin/nodes/a/stats", "token"), http.StatusNotImplemented)
+	assert.Equal(t, request(http.MethodPost, "/api/v1/admin/nodes/a/history?from=5&to=6", "token"), http.StatusOK)
+	assert.Len(t, writer.msgs, 1)
+
+	assert.Equal(t, request(http.MethodPost, "/api/v1/admin/nodes/a/history", "token"), http.StatusBadRequest)
+	assert.Equal(t, request(http.MethodPost, "/api/v1/admin/nodes/a/other", "token"), http.StatusNotFound)
+	assert.Equal(t, request(http.MethodPost, "/api/v1/admin/nodes/b/stats", "token"), http.StatusNotFound)
+}
@@ -0,0 +1,70 @@
+package ethstats
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// dialect holds the differences between the sql databases supported by State
+type dialect struct {
+	// driver is the name of the database/sql driver
+	driver string
+
+	// migrations is the directory with the embedded migrations
+	migrations string
+
+	// olderThan returns the condition that matches the rows
+	// whose column is older than the given seconds
+	olderThan func(column string, seconds int) string
+
+	// rowID is the column that identifies the physical rows of
+	// a table, used to delete the rows of tables without a key
+	rowID string
+
+	// lock acquires a lock shared by all the clients of the database
+	// and returns the function to release it
+	lock func(ctx context.Context, conn *sqlx.Conn, id int64) (func(), error)
+}
+
+var postgresDialect = &dialect{
+	driver:     "postgres",
+	migrations: "migrations/postgres",
+	olderThan: func(column string, seconds int) string {
+		return fmt.Sprintf("%s < now() - interval '%d seconds'", column, seconds)
+	},
+	rowID: "ctid",
+	lock: func(ctx context.Context, conn *sqlx.Conn, id int64) (func(), error) {
+		// session level advisory lock, it is released if the connection drops
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", id); err != nil {
+			return nil, err
+		}
+		unlock := func() {
+			conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", id)
+		}
+		return unlock, nil
+	},
+}
+
+var sqliteDialect = &dialect{
+	driver:     "sqlite3",
+	migrations: "migrations/sqlite",
+	olderThan: func(column string, seconds int) string {
+		return fmt.Sprintf("%s < strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now', '-%d seconds')", column, seconds)
+	},
+	rowID: "rowid",
+	lock: func(ctx context.Context, conn *sqlx.Conn, id int64) (func(), error) {
+		// sqlite already locks the database file on each write transaction
+		return func() {}, nil
+	},
+}
+
+func dialectForDriver(driver string) (*dialect, error) {
+	for _, d := range []*dialect{postgresDialect, sqliteDialect} {
+		if d.driver == driver {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("sql driver '%s' not supported", driver)
+}
@@ -0,0 +1,227 @@
+package ethstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	FeedTopicBlock     = "block"
+	FeedTopicStats     = "stats"
+	FeedTopicHeadEvent = "headEvent"
+	FeedTopicReorg     = "reorg"
+	FeedTopicSplit     = "split"
+)
+
+var feedTopics = map[string]struct{}{
+	FeedTopicBlock:     {},
+	FeedTopicStats:     {},
+	FeedTopicHeadEvent: {},
+	FeedTopicReorg:     {},
+	FeedTopicSplit:     {},
+}
+
+const (
+	// feedBufferSize is the number of events buffered per subscriber, the
+	// events are dropped for the subscribers that do not keep up
+	feedBufferSize = 256
+
+	// feedKeepAlive is how often a comment is sent to the idle subscribers
+	feedKeepAlive = 15 * time.Second
+)
+
+// FeedEvent is an event published to the subscribers of the live feed
+type FeedEvent struct {
+	Topic string `json:"topic"`
+
+	// NodeID is the node that reported the event, empty for
+	// the events derived from all the nodes (i.e. splits)
+	NodeID string `json:"node,omitempty"`
+
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// feedFilter selects the events sent to a subscriber, an empty set matches all the events
+type feedFilter struct {
+	topics map[string]struct{}
+	nodes  map[string]struct{}
+}
+
+func (f *feedFilter) match(evnt *FeedEvent) bool {
+	if len(f.topics) != 0 {
+		if _, ok := f.topics[evnt.Topic]; !ok {
+			return false
+		}
+	}
+	if len(f.nodes) != 0 && evnt.NodeID != "" {
+		if _, ok := f.nodes[evnt.NodeID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFeedFilter parses the comma separated 'topic' and 'node' query parameters
+func parseFeedFilter(r *http.Request) (*feedFilter, error) {
+	split := func(key string) map[string]struct{} {
+		res := map[string]struct{}{}
+		for _, val := range r.URL.Query()[key] {
+			for _, item := range strings.Split(val, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					res[item] = struct{}{}
+				}
+			}
+		}
+		return res
+	}
+
+	filter := &feedFilter{
+		topics: split("topic"),
+		nodes:  split("node"),
+	}
+	for topic := range filter.topics {
+		if _, ok := feedTopics[topic]; !ok {
+			return nil, fmt.Errorf("topic '%s' not found", topic)
+		}
+	}
+	return filter, nil
+}
+
+type feedSubscriber struct {
+	filter *feedFilter
+	ch     chan *FeedEvent
+}
+
+// eventFeed publishes the events ingested by the server to the subscribers of the live feed
+type eventFeed struct {
+	logger  hclog.Logger
+	metrics *metrics
+
+	// closeCh ends the streams when the server is closed
+	closeCh <-chan struct{}
+
+	lock        sync.Mutex
+	subscribers map[*feedSubscriber]struct{}
+}
+
+func newEventFeed(logger hclog.Logger, metrics *metrics, closeCh <-chan struct{}) *eventFeed {
+	return &eventFeed{
+		logger:      logger,
+		metrics:     metrics,
+		closeCh:     closeCh,
+		subscribers: map[*feedSubscriber]struct{}{},
+	}
+}
+
+func (f *eventFeed) subscribe(filter *feedFilter) *feedSubscriber {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	sub := &feedSubscriber{
+		filter: filter,
+		ch:     make(chan *FeedEvent, feedBufferSize),
+	}
+	f.subscribers[sub] = struct{}{}
+	f.metrics.setFeedSubscribers(len(f.subscribers))
+	return sub
+}
+
+func (f *eventFeed) unsubscribe(sub *feedSubscriber) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.subscribers, sub)
+	f.metrics.setFeedSubscribers(len(f.subscribers))
+}
+
+// publish sends the event to the matching subscribers without blocking
+func (f *eventFeed) publish(topic, nodeID string, data interface{}) {
+	if f == nil {
+		return
+	}
+	evnt := &FeedEvent{
+		Topic:  topic,
+		NodeID: nodeID,
+		Time:   time.Now().UTC(),
+		Data:   data,
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for sub := range f.subscribers {
+		if !sub.filter.match(evnt) {
+			continue
+		}
+		select {
+		case sub.ch <- evnt:
+		default:
+			f.metrics.feedEventDropped()
+		}
+	}
+}
+
+// ServeHTTP streams the events to the client as Server-Sent Events
+// until the client disconnects or the server is closed
+func (f *eventFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	filter, err := parseFeedFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+		return
+	}
+
+	sub := f.subscribe(filter)
+	defer f.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case evnt := <-sub.ch:
+			data, err := json.Marshal(evnt)
+			if err != nil {
+				f.logger.Error("failed to encode feed event", "topic", evnt.Topic, "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evnt.Topic, data); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+
+		case <-f.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ethstats
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedFilter(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/feed?topic=block,reorg&node=a", nil)
+	filter, err := parseFeedFilter(r)
+	assert.NoError(t, err)
+
+	assert.True(t, filter.match(&FeedEvent{Topic: FeedTopicBlock, NodeID: "a"}))
+	assert.False(t, filter.match(&FeedEvent{Topic: FeedTopicBlock, NodeID: "b"}))
+	assert.False(t, filter.match(&FeedEvent{Topic: FeedTopicStats, NodeID: "a"}))
+
+	// the events without a node are not filtered by node
+	filter, err = parseFeedFilter(httptest.NewRequest(http.MethodGet, "/api/v1/feed?node=a", nil))
+	assert.NoError(t, err)
+	assert.True(t, filter.match(&FeedEvent{Topic: FeedTopicSplit}))
+
+	_, err = parseFeedFilter(httptest.NewRequest(http.MethodGet, "/api/v1/feed?topic=other", nil))
+	assert.Error(t, err)
+}
+
+func TestEventFeed_SlowSubscriber(t *testing.T) {
+	feed := newEventFeed(hclog.NewNullLogger(), nil, nil)
+	sub := feed.subscribe(&feedFilter{})
+
+	// the events are dropped once the buffer is full
+	for i := 0; i < feedBufferSize+10; i++ {
+		feed.publish(FeedTopicStats, "a", i)
+	}
+	assert.Len(t, sub.ch, feedBufferSize)
+
+	feed.unsubscribe(sub)
+	assert.Len(t, feed.subscribers, 0)
+}
+
+func TestServer_Feed(t *testing.T) {
+	srv := newTestServer(t)
+
+	httpSrv := httptest.NewServer(srv.feed)
+	defer httpSrv.Close()
+
+	resp, err := http.Get(httpSrv.URL + "?topic=block,reorg&node=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	// wait for the subscription
+	for i := 0; i < 100; i++ {
+		srv.feed.lock.Lock()
+		num := len(srv.feed.subscribers)
+		srv.feed.lock.Unlock()
+		if num == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a"}}`))
+	srv.handleMessage("b", mustDecodeMsg(t, "block", `{"block": {"number": 1, "hash": "0x1b"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "stats", `{"stats": {"peers": 5}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 1, "hash": "0x1a"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "headEvent", `{
+		"event": {
+			"added": [{"hash": "0x2b", "parent_hash": "0x1", "number": 2}],
+			"removed": [{"hash": "0x2a", "parent_hash": "0x1", "number": 2}]
+		}
+	}`))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, *FeedEvent) {
+		var typ string
+		var evnt FeedEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &evnt))
+			case line == "" && typ != "":
+				return typ, &evnt
+			}
+		}
+	}
+
+	typ, evnt := readEvent()
+	assert.Equal(t, typ, FeedTopicBlock)
+	assert.Equal(t, evnt.NodeID, "a")
+	assert.Equal(t, evnt.Data.(map[string]interface{})["hash"], "0x1a")
+
+	typ, evnt = readEvent()
+	assert.Equal(t, typ, FeedTopicReorg)
+	assert.Equal(t, evnt.Data.(map[string]interface{})["commonAncestorHash"], "0x1")
+}
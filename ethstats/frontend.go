@@ -0,0 +1,113 @@
+package ethstats
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Frontend is an upstream ethstats server the messages of the nodes are proxied to
+type Frontend struct {
+	// Name identifies the frontend in the logs and metrics (the address by default)
+	Name string
+
+	// Addr is the websocket address of the frontend
+	Addr string
+
+	// Secret replaces the secret of the nodes in the hello message (if not empty)
+	Secret string
+
+	// Types are the message types proxied to the frontend, empty proxies all of them.
+	// The hello is always sent and the node-ping is never sent.
+	Types []string
+}
+
+// ParseFrontend parses a frontend from comma separated key=value pairs with
+// the keys 'name', 'addr', 'secret' and 'types' (separated by '|'), i.e.
+// name=public,addr=ws://localhost:3000/api,secret=abcd,types=block|stats
+func ParseFrontend(str string) (*Frontend, error) {
+	values, err := parseKeyValues(str, "name", "addr", "secret", "types")
+	if err != nil {
+		return nil, err
+	}
+	f := &Frontend{
+		Name:   values["name"],
+		Addr:   values["addr"],
+		Secret: values["secret"],
+	}
+	for _, typ := range strings.Split(values["types"], "|") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			f.Types = append(f.Types, typ)
+		}
+	}
+	if f.Addr == "" {
+		return nil, fmt.Errorf("frontend address not set")
+	}
+	return f, nil
+}
+
+// equal returns whether both frontends have the same settings
+func (f *Frontend) equal(other *Frontend) bool {
+	if f.Name != other.Name || f.Addr != other.Addr || f.Secret != other.Secret || len(f.Types) != len(other.Types) {
+		return false
+	}
+	for i, typ := range f.Types {
+		if typ != other.Types[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseKeyValues parses comma separated key=value pairs with the given keys
+func parseKeyValues(str string, keys ...string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.Index(item, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("'%s' is not a key=value pair", item)
+		}
+		key, val := item[:i], item[i+1:]
+
+		found := false
+		for _, k := range keys {
+			found = found || k == key
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown key '%s'", key)
+		}
+		values[key] = val
+	}
+	return values, nil
+}
+
+// frontends returns the frontends of the config including the one of the
+// frontend.addr and frontend.secret flags (if any)
+func (c *Config) frontends() ([]*Frontend, error) {
+	res := []*Frontend{}
+	if c.FrontendAddr != "" {
+		res = append(res, &Frontend{Addr: c.FrontendAddr, Secret: c.FrontendSecret})
+	}
+	res = append(res, c.Frontends...)
+
+	names := map[string]struct{}{}
+	for i, f := range res {
+		if f.Addr == "" {
+			return nil, fmt.Errorf("frontend address not set")
+		}
+		if f.Name == "" {
+			// do not modify the frontend of the config
+			cpy := *f
+			cpy.Name = f.Addr
+			res[i], f = &cpy, &cpy
+		}
+		if _, ok := names[f.Name]; ok {
+			return nil, fmt.Errorf("frontend '%s' is duplicated", f.Name)
+		}
+		names[f.Name] = struct{}{}
+	}
+	return res, nil
+}
@@ -0,0 +1,41 @@
+package ethstats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFrontend(t *testing.T) {
+	f, err := ParseFrontend("name=public,addr=ws://localhost:3000/api,secret=abcd,types=block|stats")
+	assert.NoError(t, err)
+	assert.Equal(t, f, &Frontend{
+		Name:   "public",
+		Addr:   "ws://localhost:3000/api",
+		Secret: "abcd",
+		Types:  []string{"block", "stats"},
+	})
+
+	for _, str := range []string{"", "name=public", "addr", "addr=ws://localhost,other=1"} {
+		_, err := ParseFrontend(str)
+		assert.Error(t, err)
+	}
+}
+
+func TestConfig_Frontends(t *testing.T) {
+	config := &Config{
+		FrontendAddr:   "ws://localhost:3000/api",
+		FrontendSecret: "abcd",
+		Frontends:      []*Frontend{{Name: "internal", Addr: "ws://localhost:3001/api"}},
+	}
+	frontends, err := config.frontends()
+	assert.NoError(t, err)
+	assert.Equal(t, frontends, []*Frontend{
+		{Name: "ws://localhost:3000/api", Addr: "ws://localhost:3000/api", Secret: "abcd"},
+		{Name: "internal", Addr: "ws://localhost:3001/api"},
+	})
+
+	config.Frontends = append(config.Frontends, &Frontend{Name: "internal", Addr: "ws://localhost:3002/api"})
+	_, err = config.frontends()
+	assert.Error(t, err)
+}
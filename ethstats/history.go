@@ -0,0 +1,237 @@
+package ethstats
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistoryLookback is the number of blocks below the highest block checked for gaps
+	defaultHistoryLookback = 1000
+
+	// maxHistoryRequest is the maximum number of blocks requested to a node at once
+	maxHistoryRequest = 50
+
+	// historyRequestTimeout is the time to wait for a node to send the
+	// requested blocks before they are requested again
+	historyRequestTimeout = 5 * time.Minute
+
+	// maxBlockTime is the longest block time expected from the chain, used to
+	// check that the lookback does not reach the blocks deleted by the retention
+	maxBlockTime = 15 * time.Second
+)
+
+// historyBackfill tracks the blocks requested to the nodes to fill the gaps
+// in the stored chain (i.e. after the collector has been down)
+type historyBackfill struct {
+	lock sync.Mutex
+
+	// requested is the time each missing block was requested
+	requested map[int]time.Time
+}
+
+func newHistoryBackfill() *historyBackfill {
+	return &historyBackfill{
+		requested: map[int]time.Time{},
+	}
+}
+
+// pending returns the missing blocks that have not been requested recently
+// and marks them as requested
+func (h *historyBackfill) pending(missing []int) []int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	for num, requestedAt := range h.requested {
+		if now.Sub(requestedAt) > historyRequestTimeout {
+			delete(h.requested, num)
+		}
+	}
+
+	res := []int{}
+	for _, num := range missing {
+		if _, ok := h.requested[num]; ok {
+			continue
+		}
+		h.requested[num] = now
+		res = append(res, num)
+	}
+	return res
+}
+
+// received marks the block as not missing anymore
+func (h *historyBackfill) received(num int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.requested, num)
+}
+
+// findMissingBlocks returns the numbers between from and to (inclusive)
+// that are not in the stored numbers (sorted ascending)
+func findMissingBlocks(numbers []int, from, to int) []int {
+	missing := []int{}
+	next := from
+	for _, num := range numbers {
+		for ; next < num; next++ {
+			missing = append(missing, next)
+		}
+		next = num + 1
+	}
+	for ; next <= to; next++ {
+		missing = append(missing, next)
+	}
+	return missing
+}
+
+// historyRequest returns the message that requests the blocks to a node
+func historyRequest(numbers []int) (*Msg, error) {
+	list, err := json.Marshal(numbers)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Msg{typ: "history"}
+	msg.Set("list", list)
+	return msg, nil
+}
+
+// historySessions returns the sessions of the nodes that can send history, sorted by node
+func (s *Server) historySessions() ([]*wsSession, error) {
+	s.sessionsLock.Lock()
+	sessions := []*wsSession{}
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.sessionsLock.Unlock()
+
+	res := []*wsSession{}
+	for _, session := range sessions {
+		info, err := s.state.GetNodeInfo(session.nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil && info.History {
+			res = append(res, session)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].nodeID < res[j].nodeID
+	})
+	return res, nil
+}
+
+// backfillHistory finds the gaps in the latest blocks and requests the missing
+// blocks to the nodes that can send history. The blocks below the lowest stored
+// block are not missing, since they were never stored or were deleted.
+func (s *Server) backfillHistory() error {
+	head, err := s.state.ListBlocks(0, math.MaxInt32, 1)
+	if err != nil {
+		return err
+	}
+	if len(head) == 0 {
+		return nil
+	}
+	to := head[0].Number
+
+	lookback := s.config.HistoryLookback
+	if lookback <= 0 {
+		lookback = defaultHistoryLookback
+	}
+	from := to - lookback
+	if from < 0 {
+		from = 0
+	}
+
+	numbers, err := s.state.ListBlockNumbers(from, to)
+	if err != nil {
+		return err
+	}
+	below, err := s.state.ListBlocks(0, from, 1)
+	if err != nil {
+		return err
+	}
+	if len(below) == 0 && len(numbers) != 0 {
+		from = numbers[0]
+	}
+	missing := s.history.pending(findMissingBlocks(numbers, from, to))
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sessions, err := s.historySessions()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		s.logger.Debug("no nodes available to request history", "missing", len(missing))
+		return nil
+	}
+
+	// spread the requests between the nodes
+	for i := 0; i*maxHistoryRequest < len(missing); i++ {
+		end := (i + 1) * maxHistoryRequest
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[i*maxHistoryRequest : end]
+
+		msg, err := historyRequest(batch)
+		if err != nil {
+			return err
+		}
+		session := sessions[i%len(sessions)]
+		if err := session.send(msg); err != nil {
+			s.logger.Error("failed to request history", "node", session.nodeID, "err", err)
+			continue
+		}
+		s.logger.Info("history requested", "node", session.nodeID, "from", batch[0], "to", batch[len(batch)-1], "blocks", len(batch))
+	}
+	return nil
+}
+
+// runHistoryBackfill periodically requests the missing blocks until the server is closed
+func (s *Server) runHistoryBackfill() {
+	ticker := time.NewTicker(s.config.HistoryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.backfillHistory(); err != nil {
+				s.logger.Error("failed to backfill history", "err", err)
+			}
+
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// handleHistory writes the blocks sent by a node as a reply to a history request
+func (s *Server) handleHistory(msg *Msg) error {
+	var blocks []*Block
+	if err := msg.decodeMsg("history", &blocks); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if block == nil {
+			continue
+		}
+		if err := s.state.WriteBlock(s.config, block); err != nil {
+			return err
+		}
+		s.rollups.blockChanged(block.Timestamp)
+		if err := s.canonical.addBlock(block); err != nil {
+			return err
+		}
+		s.history.received(block.Number)
+	}
+	if len(blocks) != 0 {
+		s.logger.Debug("history received", "blocks", len(blocks))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package ethstats
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockWsWriter records the messages written to a session
+type mockWsWriter struct {
+	lock sync.Mutex
+	msgs []*Msg
+}
+
+func (m *mockWsWriter) WriteMessage(messageType int, data []byte) error {
+	msg, err := DecodeMsg(data)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.msgs = append(m.msgs, msg)
+	return nil
+}
+
+func TestFindMissingBlocks(t *testing.T) {
+	assert.Equal(t, findMissingBlocks(nil, 8, 10), []int{8, 9, 10})
+	assert.Equal(t, findMissingBlocks([]int{1, 2, 3}, 1, 3), []int{})
+	assert.Equal(t, findMissingBlocks([]int{1, 4, 5, 8}, 1, 10), []int{2, 3, 6, 7, 9, 10})
+
+	assert.Equal(t, findMissingBlocks([]int{3, 5}, 0, 5), []int{0, 1, 2, 4})
+}
+
+func TestHistoryBackfill_Pending(t *testing.T) {
+	h := newHistoryBackfill()
+
+	assert.Equal(t, h.pending([]int{1, 2}), []int{1, 2})
+
+	// the blocks already requested are skipped
+	assert.Equal(t, h.pending([]int{1, 2, 3}), []int{3})
+
+	// unless they are received and go missing again
+	h.received(2)
+	assert.Equal(t, h.pending([]int{1, 2}), []int{2})
+}
+
+func TestServer_BackfillHistory(t *testing.T) {
+	srv := newTestServer(t)
+	srv.config.HistoryLookback = 3
+
+	// only b can send history
+	writers := map[string]*mockWsWriter{}
+	for _, node := range []string{"a", "b"} {
+		history := "false"
+		if node == "b" {
+			history = "true"
+		}
+		srv.handleMessage(node, mustDecodeMsg(t, "hello", `{"info": {"name": "`+node+`", "canUpdateHistory": `+history+`}}`))
+
+		writers[node] = &mockWsWriter{}
+		srv.sessionStarted(newWsSession(node, writers[node]))
+	}
+
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 1, "hash": "0x1"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 4, "hash": "0x4", "parentHash": "0x3"}}`))
+
+	assert.NoError(t, srv.backfillHistory())
+	assert.Len(t, writers["a"].msgs, 0)
+	assert.Len(t, writers["b"].msgs, 1)
+
+	req := writers["b"].msgs[0]
+	assert.Equal(t, req.typ, "history")
+
+	var list []int
+	assert.NoError(t, req.decodeMsg("list", &list))
+	assert.Equal(t, list, []int{2, 3})
+
+	// the blocks are not requested again while the request is in flight
+	assert.NoError(t, srv.backfillHistory())
+	assert.Len(t, writers["b"].msgs, 1)
+
+	// b replies with the missing blocks
+	srv.handleMessage("b", mustDecodeMsg(t, "history", `{"id": "b", "history": [
+		{"number": 2, "hash": "0x2", "parentHash": "0x1"},
+		{"number": 3, "hash": "0x3", "parentHash": "0x2"}
+	]}`))
+
+	numbers, err := srv.state.ListBlockNumbers(0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, numbers, []int{1, 2, 3, 4})
+
+	block, err := srv.state.GetCanonicalBlock(2)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, "0x2")
+
+	// the history is not an arrival of the block
+	arrivals, err := srv.state.GetBlockArrivals("0x2")
+	assert.NoError(t, err)
+	assert.Len(t, arrivals, 0)
+
+	// without sessions nothing is requested
+	srv.sessionClosed(srv.sessions["b"])
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 6, "hash": "0x6"}}`))
+	assert.NoError(t, srv.backfillHistory())
+	assert.Len(t, writers["b"].msgs, 1)
+}
+
+func TestServer_BackfillHistoryLookback(t *testing.T) {
+	srv := newTestServer(t)
+	srv.config.HistoryLookback = 5
+
+	srv.handleMessage("b", mustDecodeMsg(t, "hello", `{"info": {"name": "b", "canUpdateHistory": true}}`))
+	writer := &mockWsWriter{}
+	srv.sessionStarted(newWsSession("b", writer))
+
+	// the blocks below the lowest stored block are not requested
+	for _, num := range []string{"7", "8", "10"} {
+		srv.handleMessage("b", mustDecodeMsg(t, "block", `{"block": {"number": `+num+`, "hash": "0x`+num+`"}}`))
+	}
+
+	assert.NoError(t, srv.backfillHistory())
+	assert.Len(t, writer.msgs, 1)
+
+	var list []int
+	assert.NoError(t, writer.msgs[0].decodeMsg("list", &list))
+	assert.Equal(t, list, []int{9})
+
+	// with a block stored below the lookback, i.e. the collector was down,
+	// the whole lookback is checked
+	srv.handleMessage("b", mustDecodeMsg(t, "block", `{"block": {"number": 2, "hash": "0x2"}}`))
+	srv.history.received(9)
+
+	assert.NoError(t, srv.backfillHistory())
+	assert.Len(t, writer.msgs, 2)
+
+	assert.NoError(t, writer.msgs[1].decodeMsg("list", &list))
+	assert.Equal(t, list, []int{5, 6, 9})
+}
@@ -0,0 +1,460 @@
+package ethstats
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// knownMsgTypes are the message types tracked by the metrics, any
+// other type is reported as 'other' to bound the number of series.
+var knownMsgTypes = map[string]struct{}{
+	"hello":     {},
+	"block":     {},
+	"stats":     {},
+	"headEvent": {},
+	"pending":   {},
+	"latency":   {},
+	"history":   {},
+	"node-ping": {},
+}
+
+// metrics are the prometheus metrics of the collector and the nodes.
+// All the methods are no-ops on a nil metrics.
+type metrics struct {
+	registry *prometheus.Registry
+
+	connectedNodes   prometheus.Gauge
+	messages         *prometheus.CounterVec
+	decodeErrors     prometheus.Counter
+	authFailures     prometheus.Counter
+	writeDuration    *prometheus.HistogramVec
+	proxyQueueDepth  *prometheus.GaugeVec
+	proxyDropped     *prometheus.CounterVec
+	proxyReconnects  *prometheus.CounterVec
+	nodePeers        *prometheus.GaugeVec
+	nodeSyncing      *prometheus.GaugeVec
+	nodeActive       *prometheus.GaugeVec
+	nodeLatestNumber *prometheus.GaugeVec
+	nodeLatency      *prometheus.GaugeVec
+	nodePending      *prometheus.GaugeVec
+	blockArrival     *prometheus.HistogramVec
+	reorgs           *prometheus.CounterVec
+	reorgDepth       prometheus.Histogram
+	chainSplits      prometheus.Gauge
+	feedSubscribers  prometheus.Gauge
+	feedDropped      prometheus.Counter
+	alertsFiring     *prometheus.GaugeVec
+	alertsFailed     prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		connectedNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "connected_nodes",
+			Help:      "Number of nodes connected to the collector",
+		}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "messages_received_total",
+			Help:      "Number of messages received from the nodes by type",
+		}, []string{"type"}),
+		decodeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "decode_errors_total",
+			Help:      "Number of messages from the nodes that could not be decoded",
+		}),
+		authFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "auth_failures_total",
+			Help:      "Number of sessions that failed to authenticate",
+		}),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ethstats",
+			Name:      "db_write_duration_seconds",
+			Help:      "Duration of the writes to the store by operation",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"op"}),
+		proxyQueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "proxy_queue_depth",
+			Help:      "Number of messages waiting to be proxied to the frontend",
+		}, []string{"node", "frontend"}),
+		proxyDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "proxy_dropped_messages_total",
+			Help:      "Number of messages dropped because the proxy queue was full or the write to the frontend failed",
+		}, []string{"node", "frontend", "reason"}),
+		proxyReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "proxy_reconnects_total",
+			Help:      "Number of times the proxy reconnected to the frontend",
+		}, []string{"node", "frontend"}),
+		nodePeers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "node_peers",
+			Help:      "Number of peers reported by the node",
+		}, []string{"node"}),
+		nodeSyncing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "node_syncing",
+			Help:      "Whether the node reports that it is syncing (1) or not (0)",
+		}, []string{"node"}),
+		nodeActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "node_active",
+			Help:      "Whether the node reports that it is active (1) or not (0)",
+		}, []string{"node"}),
+		nodeLatestNumber: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "node_latest_block_number",
+			Help:      "Number of the latest block reported by the node",
+		}, []string{"node"}),
+		nodeLatency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "node_latency_seconds",
+			Help:      "Latest round-trip latency between the node and the collector reported by the node",
+		}, []string{"node"}),
+		nodePending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "node_pending_transactions",
+			Help:      "Number of pending transactions reported by the node",
+		}, []string{"node"}),
+		blockArrival: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ethstats",
+			Name:      "block_arrival_delay_seconds",
+			Help:      "Delay between the timestamp of the blocks and their arrival from the node",
+			Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64},
+		}, []string{"node"}),
+		reorgs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "reorgs_total",
+			Help:      "Number of reorgs observed by the node",
+		}, []string{"node"}),
+		reorgDepth: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "ethstats",
+			Name:      "reorg_depth",
+			Help:      "Depth of the reorgs observed by the nodes",
+			Buckets:   []float64{1, 2, 3, 5, 8, 13, 21, 34, 64, 128},
+		}),
+		chainSplits: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "chain_splits_active",
+			Help:      "Number of unresolved chain splits between the nodes",
+		}),
+		feedSubscribers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "feed_subscribers",
+			Help:      "Number of clients subscribed to the live feed",
+		}),
+		feedDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "feed_dropped_events_total",
+			Help:      "Number of live feed events dropped because a subscriber did not keep up",
+		}),
+		alertsFiring: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ethstats",
+			Name:      "alerts_firing",
+			Help:      "Number of alerts firing by rule",
+		}, []string{"rule"}),
+		alertsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ethstats",
+			Name:      "alert_notifications_failed_total",
+			Help:      "Number of alert notifications that could not be sent to a webhook",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connectedNodes,
+		m.messages,
+		m.decodeErrors,
+		m.authFailures,
+		m.writeDuration,
+		m.proxyQueueDepth,
+		m.proxyDropped,
+		m.proxyReconnects,
+		m.nodePeers,
+		m.nodeSyncing,
+		m.nodeActive,
+		m.nodeLatestNumber,
+		m.nodeLatency,
+		m.nodePending,
+		m.blockArrival,
+		m.reorgs,
+		m.reorgDepth,
+		m.chainSplits,
+		m.feedSubscribers,
+		m.feedDropped,
+		m.alertsFiring,
+		m.alertsFailed,
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) nodeConnected() {
+	if m == nil {
+		return
+	}
+	m.connectedNodes.Inc()
+}
+
+func (m *metrics) nodeDisconnected() {
+	if m == nil {
+		return
+	}
+	m.connectedNodes.Dec()
+}
+
+func (m *metrics) messageReceived(typ string) {
+	if m == nil {
+		return
+	}
+	if _, ok := knownMsgTypes[typ]; !ok {
+		typ = "other"
+	}
+	m.messages.WithLabelValues(typ).Inc()
+}
+
+func (m *metrics) decodeError() {
+	if m == nil {
+		return
+	}
+	m.decodeErrors.Inc()
+}
+
+func (m *metrics) authFailure() {
+	if m == nil {
+		return
+	}
+	m.authFailures.Inc()
+}
+
+func (m *metrics) observeWrite(op string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.writeDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) setProxyQueueDepth(nodeID, frontend string, depth int) {
+	if m == nil {
+		return
+	}
+	m.proxyQueueDepth.WithLabelValues(nodeID, frontend).Set(float64(depth))
+}
+
+func (m *metrics) proxyMessageDropped(nodeID, frontend, reason string) {
+	if m == nil {
+		return
+	}
+	m.proxyDropped.WithLabelValues(nodeID, frontend, reason).Inc()
+}
+
+func (m *metrics) proxyReconnected(nodeID, frontend string) {
+	if m == nil {
+		return
+	}
+	m.proxyReconnects.WithLabelValues(nodeID, frontend).Inc()
+}
+
+func (m *metrics) proxyClosed(nodeID, frontend string) {
+	if m == nil {
+		return
+	}
+	m.proxyQueueDepth.DeleteLabelValues(nodeID, frontend)
+}
+
+func (m *metrics) setFeedSubscribers(count int) {
+	if m == nil {
+		return
+	}
+	m.feedSubscribers.Set(float64(count))
+}
+
+func (m *metrics) feedEventDropped() {
+	if m == nil {
+		return
+	}
+	m.feedDropped.Inc()
+}
+
+func (m *metrics) updateNodeStats(nodeID string, stats *NodeStats) {
+	if m == nil {
+		return
+	}
+	m.nodePeers.WithLabelValues(nodeID).Set(float64(stats.Peers))
+	m.nodeSyncing.WithLabelValues(nodeID).Set(boolToFloat(stats.Syncing))
+	m.nodeActive.WithLabelValues(nodeID).Set(boolToFloat(stats.Active))
+}
+
+// nodeOffline marks the node as inactive once its session is closed
+func (m *metrics) nodeOffline(nodeID string) {
+	if m == nil {
+		return
+	}
+	m.nodeActive.WithLabelValues(nodeID).Set(0)
+}
+
+func (m *metrics) updateNodeBlock(nodeID string, number int) {
+	if m == nil {
+		return
+	}
+	m.nodeLatestNumber.WithLabelValues(nodeID).Set(float64(number))
+}
+
+func (m *metrics) updateNodeLatency(latency *NodeLatency) {
+	if m == nil {
+		return
+	}
+	m.nodeLatency.WithLabelValues(latency.NodeID).Set(float64(latency.Latency) / 1000)
+}
+
+func (m *metrics) updateNodePending(pending *NodePending) {
+	if m == nil {
+		return
+	}
+	m.nodePending.WithLabelValues(pending.NodeID).Set(float64(pending.Pending))
+}
+
+func (m *metrics) blockArrived(arrival *BlockArrival) {
+	if m == nil || arrival.BlockTimestamp == 0 {
+		return
+	}
+	m.blockArrival.WithLabelValues(arrival.NodeID).Observe(float64(arrival.timestampDelay()) / 1000)
+}
+
+func (m *metrics) reorgDetected(reorg *Reorg) {
+	if m == nil {
+		return
+	}
+	m.reorgs.WithLabelValues(reorg.NodeID).Inc()
+	m.reorgDepth.Observe(float64(reorg.Depth))
+}
+
+func (m *metrics) setActiveChainSplits(num int) {
+	if m == nil {
+		return
+	}
+	m.chainSplits.Set(float64(num))
+}
+
+func (m *metrics) setAlertsFiring(firing map[string]int) {
+	if m == nil {
+		return
+	}
+	m.alertsFiring.Reset()
+	for rule, num := range firing {
+		m.alertsFiring.WithLabelValues(rule).Set(float64(num))
+	}
+}
+
+func (m *metrics) alertNotificationFailed() {
+	if m == nil {
+		return
+	}
+	m.alertsFailed.Inc()
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// instrumentedStore is a Store that tracks the duration of the writes
+type instrumentedStore struct {
+	Store
+
+	metrics *metrics
+}
+
+func (m *metrics) instrumentStore(s Store) Store {
+	return &instrumentedStore{Store: s, metrics: m}
+}
+
+func (i *instrumentedStore) WriteBlock(config *Config, b *Block) error {
+	defer i.metrics.observeWrite("WriteBlock", time.Now())
+	return i.Store.WriteBlock(config, b)
+}
+
+func (i *instrumentedStore) SetBlockStatus(hash string, status string) error {
+	defer i.metrics.observeWrite("SetBlockStatus", time.Now())
+	return i.Store.SetBlockStatus(hash, status)
+}
+
+func (i *instrumentedStore) FinalizeBlocks(number int) error {
+	defer i.metrics.observeWrite("FinalizeBlocks", time.Now())
+	return i.Store.FinalizeBlocks(number)
+}
+
+func (i *instrumentedStore) WriteBlockArrival(arrival *BlockArrival) error {
+	defer i.metrics.observeWrite("WriteBlockArrival", time.Now())
+	return i.Store.WriteBlockArrival(arrival)
+}
+
+func (i *instrumentedStore) WriteNodeInfo(nodeInfo *NodeInfo) error {
+	defer i.metrics.observeWrite("WriteNodeInfo", time.Now())
+	return i.Store.WriteNodeInfo(nodeInfo)
+}
+
+func (i *instrumentedStore) WriteNodeStats(nodeID string, stats *NodeStats) error {
+	defer i.metrics.observeWrite("WriteNodeStats", time.Now())
+	return i.Store.WriteNodeStats(nodeID, stats)
+}
+
+func (i *instrumentedStore) WriteNodeLatency(latency *NodeLatency) error {
+	defer i.metrics.observeWrite("WriteNodeLatency", time.Now())
+	return i.Store.WriteNodeLatency(latency)
+}
+
+func (i *instrumentedStore) WriteNodePending(pending *NodePending) error {
+	defer i.metrics.observeWrite("WriteNodePending", time.Now())
+	return i.Store.WriteNodePending(pending)
+}
+
+func (i *instrumentedStore) WriteHeadEvent(nodeID string, evnt *HeadEvent) (string, error) {
+	defer i.metrics.observeWrite("WriteHeadEvent", time.Now())
+	return i.Store.WriteHeadEvent(nodeID, evnt)
+}
+
+func (i *instrumentedStore) SetNodeActive(nodeID string, active bool) error {
+	defer i.metrics.observeWrite("SetNodeActive", time.Now())
+	return i.Store.SetNodeActive(nodeID, active)
+}
+
+func (i *instrumentedStore) WriteSessionEvent(evnt *SessionEvent) error {
+	defer i.metrics.observeWrite("WriteSessionEvent", time.Now())
+	return i.Store.WriteSessionEvent(evnt)
+}
+
+func (i *instrumentedStore) WriteReorg(reorg *Reorg) error {
+	defer i.metrics.observeWrite("WriteReorg", time.Now())
+	return i.Store.WriteReorg(reorg)
+}
+
+func (i *instrumentedStore) WriteChainSplit(split *ChainSplit) error {
+	defer i.metrics.observeWrite("WriteChainSplit", time.Now())
+	return i.Store.WriteChainSplit(split)
+}
+
+func (i *instrumentedStore) WriteRollup(period RollupPeriod, bucket time.Time) error {
+	defer i.metrics.observeWrite("WriteRollup", time.Now())
+	return i.Store.WriteRollup(period, bucket)
+}
+
+func (i *instrumentedStore) DeleteRows(table string, cutoff Cutoff, limit int) (int, error) {
+	defer i.metrics.observeWrite("DeleteRows", time.Now())
+	return i.Store.DeleteRows(table, cutoff, limit)
+}
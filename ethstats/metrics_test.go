@@ -0,0 +1,53 @@
+package ethstats
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_NilSafe(t *testing.T) {
+	var m *metrics
+
+	m.nodeConnected()
+	m.messageReceived("block")
+	m.updateNodeStats("a", &NodeStats{})
+}
+
+func TestMetrics_MessageTypes(t *testing.T) {
+	m := newMetrics()
+
+	m.messageReceived("block")
+	m.messageReceived("block")
+	m.messageReceived("random")
+
+	assert.Equal(t, testutil.ToFloat64(m.messages.WithLabelValues("block")), float64(2))
+	assert.Equal(t, testutil.ToFloat64(m.messages.WithLabelValues("other")), float64(1))
+}
+
+func TestMetrics_Server(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 10, "hash": "0x10"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "stats", `{"stats": {"syncing": true, "peers": 5}}`))
+	srv.handleMessage("b", mustDecodeMsg(t, "block", `{"block": {"number": 10, "hash": "0x10b"}}`))
+
+	assert.Equal(t, testutil.ToFloat64(srv.metrics.nodeLatestNumber.WithLabelValues("a")), float64(10))
+	assert.Equal(t, testutil.ToFloat64(srv.metrics.nodePeers.WithLabelValues("a")), float64(5))
+	assert.Equal(t, testutil.ToFloat64(srv.metrics.nodeSyncing.WithLabelValues("a")), float64(1))
+
+	// the writes are tracked
+	rec := httptest.NewRecorder()
+	srv.metrics.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(rec.Body)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(body), `ethstats_db_write_duration_seconds_count{op="WriteBlock"} 2`))
+	assert.True(t, strings.Contains(string(body), `ethstats_db_write_duration_seconds_count{op="WriteNodeStats"} 1`))
+	assert.True(t, strings.Contains(string(body), `ethstats_db_write_duration_seconds_count{op="WriteChainSplit"} 1`))
+}
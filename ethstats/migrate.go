@@ -0,0 +1,285 @@
+package ethstats
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*/*.sql
+var migrations embed.FS
+
+// migrationsLockID is the key of the lock held while the migrations run
+const migrationsLockID = 7310936274502103410
+
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change of the database schema
+type Migration struct {
+	Version int
+	Name    string
+
+	up   string
+	down string
+}
+
+// MigrationStatus is a migration and whether it has been applied to the database
+type MigrationStatus struct {
+	*Migration
+
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts the schema migrations. The applied
+// versions are tracked in the 'schema_migrations' table.
+type Migrator struct {
+	db         *sqlx.DB
+	dialect    *dialect
+	migrations []*Migration
+}
+
+// NewMigrator creates a Migrator for the database of the endpoint
+func NewMigrator(endpoint string) (*Migrator, error) {
+	var db *sqlx.DB
+	var err error
+
+	switch scheme := endpointScheme(endpoint); scheme {
+	case "postgres", "postgresql":
+		db, err = sqlx.Open("postgres", endpoint)
+	case "sqlite", "sqlite3":
+		db, err = openSQLite(endpoint)
+	default:
+		return nil, fmt.Errorf("storage backend '%s' does not support migrations", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dialect, err := dialectForDriver(db.DriverName())
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, dialect)
+}
+
+func newMigrator(db *sqlx.DB, dialect *dialect) (*Migrator, error) {
+	migrations, err := readMigrations(dialect.migrations)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+	}
+	return m, nil
+}
+
+func readMigrations(dir string) ([]*Migration, error) {
+	files, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		match := migrationFileRegexp.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file '%s' does not match the format <version>_<name>.<up|down>.sql", file.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		data, err := fs.ReadFile(migrations, dir+"/"+file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d used by '%s' and '%s'", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.up = string(data)
+		} else {
+			migration.down = string(data)
+		}
+	}
+
+	res := []*Migration{}
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d_%s requires both up and down files", migration.Version, migration.Name)
+		}
+		res = append(res, migration)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+	return res, nil
+}
+
+func (m *Migrator) Close() {
+	m.db.Close()
+}
+
+// Up applies the first n pending migrations (all of them if n <= 0)
+// and returns the applied ones.
+func (m *Migrator) Up(n int) ([]*Migration, error) {
+	applied := []*Migration{}
+
+	err := m.withLock(func(conn *sqlx.Conn) error {
+		versions, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if n > 0 && len(applied) == n {
+				break
+			}
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			insert := `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`
+			if err := m.exec(conn, migration, migration.up, insert, migration.Version, migration.Name, time.Now().UTC()); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Down reverts the last n applied migrations and returns the reverted ones
+func (m *Migrator) Down(n int) ([]*Migration, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("the number of migrations to revert must be greater than 0")
+	}
+	reverted := []*Migration{}
+
+	err := m.withLock(func(conn *sqlx.Conn) error {
+		versions, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			remove := `DELETE FROM schema_migrations WHERE version = $1`
+			if err := m.exec(conn, migration, migration.down, remove, migration.Version); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+// Status returns all the known migrations and whether they are applied
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	res := []*MigrationStatus{}
+
+	err := m.withLock(func(conn *sqlx.Conn) error {
+		versions, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			appliedAt, applied := versions[migration.Version]
+			res = append(res, &MigrationStatus{
+				Migration: migration,
+				Applied:   applied,
+				AppliedAt: appliedAt,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// withLock runs the handler on a single connection while holding the migrations
+// lock so that concurrent instances of the backend do not migrate at the same time
+func (m *Migrator) withLock(handler func(conn *sqlx.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.dialect.lock(ctx, conn, migrationsLockID)
+	if err != nil {
+		return fmt.Errorf("failed to acquire the migrations lock: %v", err)
+	}
+	defer unlock()
+
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return handler(conn)
+}
+
+func (m *Migrator) appliedVersions(conn *sqlx.Conn) (map[int]time.Time, error) {
+	rows := []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}{}
+	if err := conn.SelectContext(context.Background(), &rows, "SELECT version, applied_at FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+
+	versions := map[int]time.Time{}
+	for _, row := range rows {
+		versions[row.Version] = row.AppliedAt
+	}
+	return versions, nil
+}
+
+// exec runs the sql of the migration and the query that tracks it in the same transaction
+func (m *Migrator) exec(conn *sqlx.Conn, migration *Migration, sql string, track string, args ...interface{}) error {
+	tx, err := conn.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(sql); err != nil {
+		return fmt.Errorf("failed to migrate sql %d_%s: %v", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.Exec(track, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
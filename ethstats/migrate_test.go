@@ -0,0 +1,89 @@
+package ethstats
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations_SameVersions(t *testing.T) {
+	// every dialect must implement the same schema versions
+	postgres, err := readMigrations(postgresDialect.migrations)
+	assert.NoError(t, err)
+
+	sqlite, err := readMigrations(sqliteDialect.migrations)
+	assert.NoError(t, err)
+
+	assert.Equal(t, len(postgres), len(sqlite))
+	for i := range postgres {
+		assert.Equal(t, postgres[i].Version, sqlite[i].Version)
+		assert.Equal(t, postgres[i].Name, sqlite[i].Name)
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	endpoint := "sqlite://" + filepath.Join(t.TempDir(), "ethstats.db")
+
+	m, err := NewMigrator(endpoint)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	total := len(m.migrations)
+
+	// apply only the first migration
+	applied, err := m.Up(1)
+	assert.NoError(t, err)
+	assert.Len(t, applied, 1)
+	assert.Equal(t, applied[0].Version, m.migrations[0].Version)
+
+	// apply the rest
+	applied, err = m.Up(0)
+	assert.NoError(t, err)
+	assert.Len(t, applied, total-1)
+
+	// nothing else to apply
+	applied, err = m.Up(0)
+	assert.NoError(t, err)
+	assert.Len(t, applied, 0)
+
+	status, err := m.Status()
+	assert.NoError(t, err)
+	assert.Len(t, status, total)
+	for _, s := range status {
+		assert.True(t, s.Applied)
+		assert.False(t, s.AppliedAt.IsZero())
+	}
+
+	// revert the last migration
+	reverted, err := m.Down(1)
+	assert.NoError(t, err)
+	assert.Len(t, reverted, 1)
+	assert.Equal(t, reverted[0].Version, m.migrations[total-1].Version)
+
+	status, err = m.Status()
+	assert.NoError(t, err)
+	assert.False(t, status[total-1].Applied)
+
+	_, err = m.Down(0)
+	assert.Error(t, err)
+
+	// the state applies the pending migrations on start
+	s, err := NewStore(endpoint)
+	assert.NoError(t, err)
+	s.Close()
+
+	status, err = m.Status()
+	assert.NoError(t, err)
+	assert.True(t, status[total-1].Applied)
+
+	// revert everything
+	reverted, err = m.Down(total)
+	assert.NoError(t, err)
+	assert.Len(t, reverted, total)
+}
+
+func TestNewMigrator_Unsupported(t *testing.T) {
+	_, err := NewMigrator("memory://")
+	assert.Error(t, err)
+}
@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS block_transactions;
+
+DROP TABLE IF EXISTS blocks;
@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS headentry;
+
+DROP TABLE IF EXISTS headevents;
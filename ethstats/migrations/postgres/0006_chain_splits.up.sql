@@ -0,0 +1,10 @@
+
+CREATE TABLE IF NOT EXISTS chain_splits (
+    split_id TEXT NOT NULL PRIMARY KEY,
+    height integer NOT NULL,
+    fork_number integer NOT NULL,
+    fork_hash TEXT,
+    branches TEXT,
+    detected_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP
+);
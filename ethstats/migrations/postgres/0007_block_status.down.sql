@@ -0,0 +1,6 @@
+
+DROP INDEX IF EXISTS blocks_number_status_idx;
+
+ALTER TABLE blocks DROP COLUMN IF EXISTS finalized;
+
+ALTER TABLE blocks DROP COLUMN IF EXISTS status;
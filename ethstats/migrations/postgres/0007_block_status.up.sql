@@ -0,0 +1,6 @@
+
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'unknown';
+
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS finalized boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS blocks_number_status_idx ON blocks (number, status);
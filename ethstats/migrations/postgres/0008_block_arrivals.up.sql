@@ -0,0 +1,13 @@
+
+CREATE TABLE IF NOT EXISTS block_arrivals (
+    node_id TEXT NOT NULL REFERENCES nodeinfo(node_id),
+    block_hash TEXT NOT NULL,
+    block_number integer NOT NULL,
+    block_timestamp integer NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (node_id, block_hash)
+);
+
+CREATE INDEX IF NOT EXISTS block_arrivals_block_number_idx ON block_arrivals (block_number);
+
+CREATE INDEX IF NOT EXISTS block_arrivals_block_hash_idx ON block_arrivals (block_hash);
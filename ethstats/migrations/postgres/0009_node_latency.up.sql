@@ -0,0 +1,8 @@
+
+CREATE TABLE IF NOT EXISTS node_latency (
+    node_id TEXT NOT NULL REFERENCES nodeinfo(node_id),
+    latency integer NOT NULL,
+    reported_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS node_latency_node_id_reported_at_idx ON node_latency (node_id, reported_at);
@@ -0,0 +1,2 @@
+
+DROP TABLE IF EXISTS nodestats_history;
@@ -0,0 +1,14 @@
+
+CREATE TABLE IF NOT EXISTS nodestats_history (
+    node_id TEXT NOT NULL REFERENCES nodeinfo(node_id),
+    active boolean,
+    syncing boolean,
+    mining boolean,
+    hashrate integer,
+    peers integer,
+    gasprice integer,
+    uptime integer,
+    reported_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS nodestats_history_node_id_reported_at_idx ON nodestats_history (node_id, reported_at);
@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS node_rollups;
+
+DROP TABLE IF EXISTS chain_rollups;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS session_events (
+    event_id TEXT NOT NULL PRIMARY KEY,
+    node_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    remote_addr TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    messages integer NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS session_events_node_id_idx ON session_events (node_id);
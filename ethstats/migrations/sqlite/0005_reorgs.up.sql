@@ -0,0 +1,13 @@
+
+CREATE TABLE IF NOT EXISTS reorgs (
+    event_id TEXT NOT NULL PRIMARY KEY,
+    node_id TEXT REFERENCES nodeinfo(node_id),
+    common_ancestor_hash TEXT,
+    common_ancestor_number integer NOT NULL,
+    depth integer NOT NULL,
+    dropped_hashes TEXT,
+    new_hashes TEXT,
+    detected_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS reorgs_node_id_idx ON reorgs (node_id);
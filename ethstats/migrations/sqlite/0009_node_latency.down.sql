@@ -0,0 +1,2 @@
+
+DROP TABLE IF EXISTS node_latency;
@@ -0,0 +1,21 @@
+
+CREATE TABLE IF NOT EXISTS chain_rollups (
+    period TEXT NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    block_count integer NOT NULL,
+    avg_gas_ratio double precision NOT NULL,
+    avg_block_time double precision NOT NULL,
+    reorg_count integer NOT NULL,
+    PRIMARY KEY (period, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS node_rollups (
+    period TEXT NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    node_id TEXT NOT NULL,
+    samples integer NOT NULL,
+    avg_peers double precision NOT NULL,
+    avg_uptime double precision NOT NULL,
+    active_ratio double precision NOT NULL,
+    PRIMARY KEY (period, bucket, node_id)
+);
@@ -0,0 +1,162 @@
+package ethstats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// BlockArrival is the time when a node reported a block to the collector
+type BlockArrival struct {
+	NodeID         string    `json:"node" db:"node_id"`
+	BlockHash      string    `json:"hash" db:"block_hash"`
+	BlockNumber    int       `json:"number" db:"block_number"`
+	BlockTimestamp int       `json:"timestamp" db:"block_timestamp"`
+	ReceivedAt     time.Time `json:"receivedAt" db:"received_at"`
+}
+
+// timestampDelay returns the milliseconds since the timestamp of the block
+func (b *BlockArrival) timestampDelay() int64 {
+	return b.ReceivedAt.UnixNano()/int64(time.Millisecond) - int64(b.BlockTimestamp)*1000
+}
+
+// BlockPropagation is how a block propagated across the nodes
+type BlockPropagation struct {
+	Hash      string `json:"hash"`
+	Number    int    `json:"number"`
+	Timestamp int    `json:"timestamp"`
+
+	// FirstSeen and FirstNode are the first arrival of the block
+	FirstSeen time.Time `json:"firstSeen"`
+	FirstNode string    `json:"firstNode"`
+
+	// Arrivals are the arrivals at each node sorted by reception time
+	Arrivals []*NodeArrival `json:"arrivals"`
+}
+
+// NodeArrival is the arrival of a block at a node. The delays are in milliseconds.
+type NodeArrival struct {
+	NodeID     string    `json:"node"`
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	// Delay is the time since the first node reported the block
+	Delay int64 `json:"delay"`
+
+	// TimestampDelay is the time since the timestamp of the block
+	TimestampDelay int64 `json:"timestampDelay"`
+}
+
+// PropagationStats are the propagation delays of a node over a set of blocks.
+// The delays are in milliseconds.
+type PropagationStats struct {
+	NodeID string `json:"node"`
+
+	// Blocks is the number of blocks reported by the node
+	Blocks int `json:"blocks"`
+
+	// FirstSeen is the number of blocks the node reported before any other node
+	FirstSeen int `json:"firstSeen"`
+
+	DelayP50          int64 `json:"delayP50"`
+	DelayP95          int64 `json:"delayP95"`
+	TimestampDelayP50 int64 `json:"timestampDelayP50"`
+	TimestampDelayP95 int64 `json:"timestampDelayP95"`
+}
+
+// blockPropagation returns the propagation of a block from its arrivals
+// or nil if there are no arrivals
+func blockPropagation(arrivals []*BlockArrival) *BlockPropagation {
+	if len(arrivals) == 0 {
+		return nil
+	}
+
+	sorted := append([]*BlockArrival{}, arrivals...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ReceivedAt.Before(sorted[j].ReceivedAt)
+	})
+
+	first := sorted[0]
+	res := &BlockPropagation{
+		Hash:      first.BlockHash,
+		Number:    first.BlockNumber,
+		Timestamp: first.BlockTimestamp,
+		FirstSeen: first.ReceivedAt,
+		FirstNode: first.NodeID,
+		Arrivals:  []*NodeArrival{},
+	}
+	for _, arrival := range sorted {
+		res.Arrivals = append(res.Arrivals, &NodeArrival{
+			NodeID:         arrival.NodeID,
+			ReceivedAt:     arrival.ReceivedAt,
+			Delay:          int64(arrival.ReceivedAt.Sub(first.ReceivedAt) / time.Millisecond),
+			TimestampDelay: arrival.timestampDelay(),
+		})
+	}
+	return res
+}
+
+// propagationStats returns the propagation stats of each node (sorted by id)
+// from the arrivals of a set of blocks
+func propagationStats(arrivals []*BlockArrival) []*PropagationStats {
+	byHash := map[string][]*BlockArrival{}
+	for _, arrival := range arrivals {
+		byHash[arrival.BlockHash] = append(byHash[arrival.BlockHash], arrival)
+	}
+
+	type nodeDelays struct {
+		stats          *PropagationStats
+		delays         []int64
+		timestampDelay []int64
+	}
+	nodes := map[string]*nodeDelays{}
+
+	for _, blockArrivals := range byHash {
+		propagation := blockPropagation(blockArrivals)
+		for _, arrival := range propagation.Arrivals {
+			n, ok := nodes[arrival.NodeID]
+			if !ok {
+				n = &nodeDelays{stats: &PropagationStats{NodeID: arrival.NodeID}}
+				nodes[arrival.NodeID] = n
+			}
+			n.stats.Blocks++
+			if arrival.NodeID == propagation.FirstNode {
+				n.stats.FirstSeen++
+			}
+			n.delays = append(n.delays, arrival.Delay)
+			n.timestampDelay = append(n.timestampDelay, arrival.TimestampDelay)
+		}
+	}
+
+	res := []*PropagationStats{}
+	for _, n := range nodes {
+		n.stats.DelayP50 = percentile(n.delays, 50)
+		n.stats.DelayP95 = percentile(n.delays, 95)
+		n.stats.TimestampDelayP50 = percentile(n.timestampDelay, 50)
+		n.stats.TimestampDelayP95 = percentile(n.timestampDelay, 95)
+		res = append(res, n.stats)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].NodeID < res[j].NodeID
+	})
+	return res
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of the values
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,65 @@
+package ethstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []int64{}
+	for i := int64(100); i > 0; i-- {
+		values = append(values, i)
+	}
+	assert.Equal(t, percentile(values, 50), int64(50))
+	assert.Equal(t, percentile(values, 95), int64(95))
+	assert.Equal(t, percentile(values, 100), int64(100))
+	assert.Equal(t, percentile([]int64{7}, 95), int64(7))
+	assert.Equal(t, percentile(nil, 50), int64(0))
+}
+
+func TestPropagationStats(t *testing.T) {
+	base := time.Unix(1000, 0).UTC()
+
+	arrival := func(node, hash string, timestamp int, delay time.Duration) *BlockArrival {
+		return &BlockArrival{
+			NodeID:         node,
+			BlockHash:      hash,
+			BlockTimestamp: timestamp,
+			ReceivedAt:     base.Add(delay),
+		}
+	}
+	arrivals := []*BlockArrival{
+		// a is first on 0x1 and b on 0x2
+		arrival("a", "0x1", 1000, 100*time.Millisecond),
+		arrival("b", "0x1", 1000, 300*time.Millisecond),
+		arrival("b", "0x2", 1002, 2*time.Second+200*time.Millisecond),
+		arrival("a", "0x2", 1002, 2*time.Second+700*time.Millisecond),
+		arrival("a", "0x3", 1004, 4*time.Second),
+	}
+
+	propagation := blockPropagation(arrivals[2:4])
+	assert.Equal(t, propagation.FirstNode, "b")
+	assert.Equal(t, propagation.Arrivals[1].NodeID, "a")
+	assert.Equal(t, propagation.Arrivals[1].Delay, int64(500))
+	assert.Equal(t, propagation.Arrivals[1].TimestampDelay, int64(700))
+
+	stats := propagationStats(arrivals)
+	assert.Len(t, stats, 2)
+
+	a := stats[0]
+	assert.Equal(t, a.NodeID, "a")
+	assert.Equal(t, a.Blocks, 3)
+	assert.Equal(t, a.FirstSeen, 2)
+	assert.Equal(t, a.DelayP50, int64(0))
+	assert.Equal(t, a.DelayP95, int64(500))
+	assert.Equal(t, a.TimestampDelayP50, int64(100))
+	assert.Equal(t, a.TimestampDelayP95, int64(700))
+
+	b := stats[1]
+	assert.Equal(t, b.NodeID, "b")
+	assert.Equal(t, b.Blocks, 2)
+	assert.Equal(t, b.FirstSeen, 1)
+	assert.Equal(t, b.DelayP95, int64(200))
+}
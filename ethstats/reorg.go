@@ -0,0 +1,65 @@
+package ethstats
+
+import (
+	"sort"
+	"time"
+)
+
+// Reorg is a chain reorganization observed by a node
+type Reorg struct {
+	// EventID is the id of the head event that reported the reorg
+	EventID string `json:"eventId" db:"event_id"`
+
+	NodeID               string    `json:"node" db:"node_id"`
+	CommonAncestorHash   string    `json:"commonAncestorHash" db:"common_ancestor_hash"`
+	CommonAncestorNumber int       `json:"commonAncestorNumber" db:"common_ancestor_number"`
+	Depth                int       `json:"depth" db:"depth"`
+	Dropped              hashList  `json:"dropped" db:"dropped_hashes"`
+	Added                hashList  `json:"added" db:"new_hashes"`
+	DetectedAt           time.Time `json:"detectedAt" db:"detected_at"`
+}
+
+// detectReorg returns the reorg described by the head event or nil
+// if the event did not remove any block from the chain of the node.
+func detectReorg(nodeID, eventID string, evnt *HeadEvent) *Reorg {
+	if len(evnt.Removed) == 0 {
+		return nil
+	}
+
+	removed := sortedStubs(evnt.Removed)
+	added := sortedStubs(evnt.Added)
+
+	// the parent of the first dropped block is the last block
+	// shared by both the old and the new chain
+	first := removed[0]
+	ancestorHash := first.ParentHash
+	if ancestorHash == "" && len(added) != 0 && added[0].Number == first.Number {
+		ancestorHash = added[0].ParentHash
+	}
+
+	reorg := &Reorg{
+		EventID:              eventID,
+		NodeID:               nodeID,
+		CommonAncestorHash:   ancestorHash,
+		CommonAncestorNumber: first.Number - 1,
+		Depth:                len(removed),
+		Dropped:              hashList{},
+		Added:                hashList{},
+		DetectedAt:           time.Now().UTC(),
+	}
+	for _, stub := range removed {
+		reorg.Dropped = append(reorg.Dropped, stub.Hash)
+	}
+	for _, stub := range added {
+		reorg.Added = append(reorg.Added, stub.Hash)
+	}
+	return reorg
+}
+
+func sortedStubs(stubs []BlockStub) []BlockStub {
+	res := append([]BlockStub{}, stubs...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Number < res[j].Number
+	})
+	return res
+}
@@ -0,0 +1,46 @@
+package ethstats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectReorg(t *testing.T) {
+	// no blocks removed, no reorg
+	evnt := &HeadEvent{
+		Added: []BlockStub{{Hash: "0x2", ParentHash: "0x1", Number: 2}},
+	}
+	assert.Nil(t, detectReorg("a", "id", evnt))
+
+	evnt = &HeadEvent{
+		Added: []BlockStub{
+			{Hash: "0x3b", ParentHash: "0x2b", Number: 3},
+			{Hash: "0x2b", ParentHash: "0x1", Number: 2},
+			{Hash: "0x4b", ParentHash: "0x3b", Number: 4},
+		},
+		Removed: []BlockStub{
+			{Hash: "0x3a", ParentHash: "0x2a", Number: 3},
+			{Hash: "0x2a", ParentHash: "0x1", Number: 2},
+		},
+	}
+	reorg := detectReorg("a", "id", evnt)
+	assert.Equal(t, reorg.NodeID, "a")
+	assert.Equal(t, reorg.EventID, "id")
+	assert.Equal(t, reorg.CommonAncestorHash, "0x1")
+	assert.Equal(t, reorg.CommonAncestorNumber, 1)
+	assert.Equal(t, reorg.Depth, 2)
+	assert.Equal(t, reorg.Dropped, hashList{"0x2a", "0x3a"})
+	assert.Equal(t, reorg.Added, hashList{"0x2b", "0x3b", "0x4b"})
+}
+
+func TestDetectReorg_AncestorFromAdded(t *testing.T) {
+	// the parent of the removed block is unknown
+	evnt := &HeadEvent{
+		Added:   []BlockStub{{Hash: "0x2b", ParentHash: "0x1", Number: 2}},
+		Removed: []BlockStub{{Hash: "0x2a", Number: 2}},
+	}
+	reorg := detectReorg("a", "id", evnt)
+	assert.Equal(t, reorg.CommonAncestorHash, "0x1")
+	assert.Equal(t, reorg.Depth, 1)
+}
@@ -0,0 +1,274 @@
+package ethstats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TableBlocks           = "blocks"
+	TableBlockTxs         = "block_transactions"
+	TableBlockArrivals    = "block_arrivals"
+	TableHeadEvents       = "headevents"
+	TableNodeLatency      = "node_latency"
+	TableNodePending      = "node_pending"
+	TableNodeStatsHistory = "nodestats_history"
+	TableSessionEvents    = "session_events"
+	TableNodeStats        = "nodestats"
+	TableNodeInfo         = "nodeinfo"
+)
+
+// PurgeTables are the tables that can be purged in the order they have to be purged.
+// The nodes are last since they are only deleted once no other row references them.
+var PurgeTables = []string{
+	TableBlockTxs,
+	TableBlocks,
+	TableBlockArrivals,
+	TableHeadEvents,
+	TableNodeLatency,
+	TableNodePending,
+	TableNodeStatsHistory,
+	TableSessionEvents,
+	TableNodeStats,
+	TableNodeInfo,
+}
+
+// Cutoff selects the rows of a table to delete. If both fields are set
+// the rows have to match both of them.
+type Cutoff struct {
+	// Age matches the rows older than the duration (if not zero)
+	Age time.Duration
+
+	// BlockNumber matches the rows of the blocks with a lower number (if not zero)
+	BlockNumber int
+}
+
+func (c Cutoff) seconds() int {
+	return int(c.Age / time.Second)
+}
+
+// ParseCutoff parses either a RFC3339 time or a block number
+func ParseCutoff(str string) (Cutoff, error) {
+	if num, err := strconv.Atoi(str); err == nil {
+		if num <= 0 {
+			return Cutoff{}, fmt.Errorf("block number must be greater than 0")
+		}
+		return Cutoff{BlockNumber: num}, nil
+	}
+	before, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return Cutoff{}, fmt.Errorf("'%s' is neither a RFC3339 time nor a block number", str)
+	}
+	age := time.Since(before)
+	if age <= 0 {
+		return Cutoff{}, fmt.Errorf("time %s is in the future", str)
+	}
+	return Cutoff{Age: age}, nil
+}
+
+// defaultRetentionBatchSize is the number of rows deleted at once by the retention
+const defaultRetentionBatchSize = 1000
+
+// RetentionConfig is how long the rows of each table are kept, 0 keeps them forever
+type RetentionConfig struct {
+	Blocks           time.Duration
+	BlockTxs         time.Duration
+	HeadEvents       time.Duration
+	NodeStatsHistory time.Duration
+}
+
+type tableRetention struct {
+	table     string
+	retention time.Duration
+}
+
+// tables returns the tables with a retention. The transactions go before
+// the blocks since deleting the blocks also deletes their transactions.
+func (r RetentionConfig) tables() []tableRetention {
+	res := []tableRetention{}
+	for _, t := range []tableRetention{
+		{TableBlockTxs, r.BlockTxs},
+		{TableBlocks, r.Blocks},
+		{TableHeadEvents, r.HeadEvents},
+		{TableNodeStatsHistory, r.NodeStatsHistory},
+	} {
+		if t.retention > 0 {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// rollupCutoff returns the start of the rows the rollups are computed from that are
+// still complete at now, given the retention of the blocks and the stats history.
+// It is zero if those rows are kept forever.
+func (r RetentionConfig) rollupCutoff(now time.Time) time.Time {
+	retention := time.Duration(0)
+	for _, d := range []time.Duration{r.Blocks, r.NodeStatsHistory} {
+		if d > 0 && (retention == 0 || d < retention) {
+			retention = d
+		}
+	}
+	if retention == 0 {
+		return time.Time{}
+	}
+	return now.Add(-retention)
+}
+
+// Validate returns an error if the rows of the table cannot be selected by the cutoff
+func (c Cutoff) Validate(table string) error {
+	switch table {
+	case TableNodeStats:
+		// the stats without a node, the cutoff does not apply
+		return nil
+
+	case TableNodeInfo:
+		if c.Age == 0 {
+			return fmt.Errorf("table '%s' can only be purged by time", table)
+		}
+		return nil
+
+	case TableNodeLatency, TableNodePending, TableNodeStatsHistory, TableSessionEvents:
+		if c.BlockNumber != 0 {
+			return fmt.Errorf("table '%s' cannot be purged by block number", table)
+		}
+
+	case TableBlocks, TableBlockTxs, TableBlockArrivals, TableHeadEvents:
+
+	default:
+		return fmt.Errorf("table '%s' cannot be purged", table)
+	}
+	if c.Age == 0 && c.BlockNumber == 0 {
+		return fmt.Errorf("no cutoff to purge table '%s'", table)
+	}
+	return nil
+}
+
+// purgeCond returns the condition (and its arguments) that matches the rows of the table selected by the cutoff
+func purgeCond(d *dialect, table string, cutoff Cutoff) (string, []interface{}, error) {
+	if err := cutoff.Validate(table); err != nil {
+		return "", nil, err
+	}
+
+	conds := []string{}
+	args := []interface{}{}
+
+	byAge := func(column string) {
+		if cutoff.Age != 0 {
+			conds = append(conds, d.olderThan(column, cutoff.seconds()))
+		}
+	}
+	byNumber := func(cond string) {
+		if cutoff.BlockNumber != 0 {
+			args = append(args, cutoff.BlockNumber)
+			conds = append(conds, fmt.Sprintf(cond, len(args)))
+		}
+	}
+
+	switch table {
+	case TableBlocks, TableBlockTxs:
+		byAge("blocks.created_at")
+		byNumber("blocks.number < $%d")
+
+	case TableBlockArrivals:
+		byAge("block_arrivals.received_at")
+		byNumber("block_arrivals.block_number < $%d")
+
+	case TableHeadEvents:
+		byAge("headevents.created_at")
+		byNumber("event_id IN (SELECT event_id FROM headentry GROUP BY event_id HAVING max(block_number) < $%d)")
+
+	case TableNodeLatency, TableNodePending, TableNodeStatsHistory:
+		byAge(table + ".reported_at")
+
+	case TableSessionEvents:
+		byAge("session_events.created_at")
+
+	case TableNodeStats:
+		conds = append(conds, "(nodestats.node_id IS NULL OR NOT EXISTS (SELECT 1 FROM nodeinfo WHERE nodeinfo.node_id = nodestats.node_id))")
+
+	case TableNodeInfo:
+		// the nodes without stats since the cutoff that are not referenced by any other row
+		conds = append(conds, "NOT EXISTS (SELECT 1 FROM nodestats WHERE nodestats.node_id = nodeinfo.node_id AND NOT ("+d.olderThan("nodestats.updated_at", cutoff.seconds())+"))")
+		for _, ref := range []string{"headevents", "reorgs", "block_arrivals", "node_latency", "node_pending", "nodestats_history"} {
+			conds = append(conds, fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s.node_id = nodeinfo.node_id)", ref, ref))
+		}
+	}
+
+	cond := strings.Join(conds, " AND ")
+	if table == TableBlockTxs {
+		cond = "block_hash IN (SELECT hash FROM blocks WHERE " + cond + ")"
+	}
+	return cond, args, nil
+}
+
+// applyRetention recomputes the rollups with late blocks, computes the pending ones
+// and deletes in batches the rows older than the retention of each table. The
+// deletes stop if the server is closed.
+func (s *Server) applyRetention() error {
+	now := time.Now()
+	recomputed, err := recomputeRollups(s.state, s.rollups.take(), s.config.Retention.rollupCutoff(now))
+	if err != nil {
+		return err
+	}
+	if recomputed != 0 {
+		s.logger.Info("rollups recomputed with late blocks", "buckets", recomputed)
+	}
+
+	buckets, err := RunRollups(s.state, now)
+	if err != nil {
+		return err
+	}
+	if buckets != 0 {
+		s.logger.Info("rollups computed", "buckets", buckets)
+	}
+
+	batchSize := s.config.RetentionBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRetentionBatchSize
+	}
+
+	for _, t := range s.config.Retention.tables() {
+		total := 0
+		for {
+			select {
+			case <-s.closeCh:
+				return nil
+			default:
+			}
+
+			deleted, err := s.state.DeleteRows(t.table, Cutoff{Age: t.retention}, batchSize)
+			if err != nil {
+				return fmt.Errorf("failed to delete rows of %s: %v", t.table, err)
+			}
+			total += deleted
+			if deleted < batchSize {
+				break
+			}
+		}
+		if total != 0 {
+			s.logger.Info("old rows removed", "table", t.table, "rows", total, "retention", t.retention)
+		}
+	}
+	return nil
+}
+
+// runRetention periodically applies the retention until the server is closed
+func (s *Server) runRetention() {
+	ticker := time.NewTicker(s.config.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.applyRetention(); err != nil {
+				s.logger.Error("failed to apply retention", "err", err)
+			}
+
+		case <-s.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package ethstats
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionConfig_Tables(t *testing.T) {
+	config := RetentionConfig{Blocks: time.Hour, BlockTxs: time.Minute}
+
+	tables := []string{}
+	for _, t := range config.tables() {
+		tables = append(tables, t.table)
+	}
+	assert.Equal(t, tables, []string{TableBlockTxs, TableBlocks})
+}
+
+func TestServer_ApplyRetention(t *testing.T) {
+	srv := newTestServer(t)
+	srv.config.RetentionBatchSize = 1
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a"}}`))
+	for _, hash := range []string{"0x1", "0x2", "0x3"} {
+		srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 1, "hash": "`+hash+`"}}`))
+	}
+	srv.handleMessage("a", mustDecodeMsg(t, "stats", `{"stats": {"peers": 5}}`))
+
+	// only the tables with a retention are purged
+	srv.config.Retention = RetentionConfig{Blocks: time.Nanosecond}
+	assert.NoError(t, srv.applyRetention())
+
+	blocks, err := srv.state.ListBlocks(0, 10, 10)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 0)
+
+	history, err := srv.state.ListNodeStatsHistory("a", time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// the rollups are computed before the data is deleted
+	last, err := srv.state.LastRollup(RollupHourly)
+	assert.NoError(t, err)
+	assert.False(t, last.IsZero())
+}
+
+func TestServer_ApplyRetentionLateBlocks(t *testing.T) {
+	srv := newTestServer(t)
+
+	bucket := RollupHourly.truncate(time.Now().Add(-2 * time.Hour))
+	timestamp := func(d time.Duration) string {
+		return strconv.Itoa(int(bucket.Add(d).Unix()))
+	}
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a", "canUpdateHistory": true}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 1, "hash": "0x1", "timestamp": `+timestamp(time.Minute)+`}}`))
+	assert.NoError(t, srv.applyRetention())
+
+	// the block backfilled after the bucket was computed is included on the next run
+	srv.handleMessage("a", mustDecodeMsg(t, "history", `{"id": "a", "history": [
+		{"number": 2, "hash": "0x2", "parentHash": "0x1", "timestamp": `+timestamp(2*time.Minute)+`}
+	]}`))
+	assert.NoError(t, srv.applyRetention())
+
+	rollups, err := srv.state.ListChainRollups(RollupHourly, bucket, bucket.Add(time.Hour), 1)
+	assert.NoError(t, err)
+	assert.Len(t, rollups, 1)
+	assert.Equal(t, rollups[0].BlockCount, 2)
+}
+
+func TestParseCutoff(t *testing.T) {
+	cutoff, err := ParseCutoff("1000")
+	assert.NoError(t, err)
+	assert.Equal(t, cutoff, Cutoff{BlockNumber: 1000})
+
+	cutoff, err = ParseCutoff(time.Now().Add(-time.Hour).Format(time.RFC3339))
+	assert.NoError(t, err)
+	assert.InDelta(t, cutoff.Age.Seconds(), time.Hour.Seconds(), 5)
+
+	for _, str := range []string{"0", "yesterday", time.Now().Add(time.Hour).Format(time.RFC3339)} {
+		_, err = ParseCutoff(str)
+		assert.Error(t, err)
+	}
+}
+
+func TestCutoff_Validate(t *testing.T) {
+	byNumber := Cutoff{BlockNumber: 10}
+	assert.NoError(t, byNumber.Validate(TableHeadEvents))
+	assert.NoError(t, byNumber.Validate(TableNodeStats))
+	assert.Error(t, byNumber.Validate(TableNodeInfo))
+	assert.Error(t, byNumber.Validate(TableNodeStatsHistory))
+
+	byAge := Cutoff{Age: time.Hour}
+	assert.NoError(t, byAge.Validate(TableNodeInfo))
+	assert.Error(t, byAge.Validate("chain_splits"))
+
+	assert.Error(t, Cutoff{}.Validate(TableBlocks))
+}
@@ -0,0 +1,188 @@
+package ethstats
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RollupPeriod is the size of the buckets of a rollup
+type RollupPeriod string
+
+const (
+	RollupHourly RollupPeriod = "hour"
+	RollupDaily  RollupPeriod = "day"
+)
+
+// rollupPeriods are the periods computed by RunRollups
+var rollupPeriods = []RollupPeriod{RollupHourly, RollupDaily}
+
+// defaultRollupBackfill is how far back the rollups start if none has been computed yet
+const defaultRollupBackfill = 90 * 24 * time.Hour
+
+func ParseRollupPeriod(str string) (RollupPeriod, error) {
+	for _, period := range rollupPeriods {
+		if string(period) == str {
+			return period, nil
+		}
+	}
+	return "", fmt.Errorf("rollup period '%s' not found", str)
+}
+
+func (p RollupPeriod) duration() time.Duration {
+	if p == RollupDaily {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// truncate returns the start of the bucket (in UTC) that contains t
+func (p RollupPeriod) truncate(t time.Time) time.Time {
+	return t.UTC().Truncate(p.duration())
+}
+
+// ChainRollup are the aggregates of the chain over a bucket
+type ChainRollup struct {
+	Period RollupPeriod `json:"period" db:"period"`
+	Bucket time.Time    `json:"bucket" db:"bucket"`
+
+	// BlockCount is the number of blocks (excluding orphaned and uncles)
+	// whose timestamp falls in the bucket
+	BlockCount int `json:"blockCount" db:"block_count"`
+
+	// AvgGasRatio is the average ratio of gas used over the gas limit
+	AvgGasRatio float64 `json:"avgGasRatio" db:"avg_gas_ratio"`
+
+	// AvgBlockTime is the average time between blocks in seconds
+	AvgBlockTime float64 `json:"avgBlockTime" db:"avg_block_time"`
+
+	// ReorgCount is the number of reorgs observed by the nodes
+	ReorgCount int `json:"reorgCount" db:"reorg_count"`
+}
+
+// NodeRollup are the aggregates of the stats of a node over a bucket
+type NodeRollup struct {
+	Period RollupPeriod `json:"period" db:"period"`
+	Bucket time.Time    `json:"bucket" db:"bucket"`
+	NodeID string       `json:"node" db:"node_id"`
+
+	// Samples is the number of stats reported by the node
+	Samples     int     `json:"samples" db:"samples"`
+	AvgPeers    float64 `json:"avgPeers" db:"avg_peers"`
+	AvgUptime   float64 `json:"avgUptime" db:"avg_uptime"`
+	ActiveRatio float64 `json:"activeRatio" db:"active_ratio"`
+}
+
+// avgBlockTime returns the average time between the blocks
+// given the number of blocks and their first and last timestamp
+func avgBlockTime(count int, minTimestamp, maxTimestamp int64) float64 {
+	if count < 2 {
+		return 0
+	}
+	return float64(maxTimestamp-minTimestamp) / float64(count-1)
+}
+
+// RunRollups computes the rollups of every period for the complete buckets
+// up to until, starting after the last computed bucket. It returns the
+// number of buckets computed.
+func RunRollups(state Store, until time.Time) (int, error) {
+	count := 0
+	for _, period := range rollupPeriods {
+		last, err := state.LastRollup(period)
+		if err != nil {
+			return count, err
+		}
+
+		var start time.Time
+		if last.IsZero() {
+			start = period.truncate(until.Add(-defaultRollupBackfill))
+		} else {
+			start = last.UTC().Add(period.duration())
+		}
+
+		// only the buckets that are complete
+		end := period.truncate(until)
+		for bucket := start; bucket.Before(end); bucket = bucket.Add(period.duration()) {
+			if err := state.WriteRollup(period, bucket); err != nil {
+				return count, fmt.Errorf("failed to compute %s rollup at %s: %v", period, bucket.Format(time.RFC3339), err)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// staleRollups tracks the buckets with blocks written or whose status changed,
+// so the buckets already computed are recomputed with the late blocks
+// (i.e. the blocks backfilled from the history or orphaned by a reorg)
+type staleRollups struct {
+	lock    sync.Mutex
+	buckets map[RollupPeriod]map[int64]struct{}
+}
+
+func newStaleRollups() *staleRollups {
+	return &staleRollups{buckets: map[RollupPeriod]map[int64]struct{}{}}
+}
+
+// blockChanged marks the buckets that contain the block timestamp as stale
+func (s *staleRollups) blockChanged(timestamp int) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, period := range rollupPeriods {
+		if s.buckets[period] == nil {
+			s.buckets[period] = map[int64]struct{}{}
+		}
+		bucket := period.truncate(time.Unix(int64(timestamp), 0))
+		s.buckets[period][bucket.Unix()] = struct{}{}
+	}
+}
+
+// take returns the stale buckets of each period sorted from oldest to newest and resets them
+func (s *staleRollups) take() map[RollupPeriod][]time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stale := map[RollupPeriod][]time.Time{}
+	for period, buckets := range s.buckets {
+		for bucket := range buckets {
+			stale[period] = append(stale[period], time.Unix(bucket, 0).UTC())
+		}
+		sort.Slice(stale[period], func(i, j int) bool {
+			return stale[period][i].Before(stale[period][j])
+		})
+	}
+	s.buckets = map[RollupPeriod]map[int64]struct{}{}
+	return stale
+}
+
+// recomputeRollups recomputes the stale buckets that were already computed. The
+// other buckets are left to RunRollups once they are complete. The buckets that
+// start before since are kept as they are, since the retention may have deleted
+// part of their rows. It returns the number of buckets recomputed.
+func recomputeRollups(state Store, stale map[RollupPeriod][]time.Time, since time.Time) (int, error) {
+	count := 0
+	for _, period := range rollupPeriods {
+		for _, bucket := range stale[period] {
+			if bucket.Before(since) {
+				continue
+			}
+			rollups, err := state.ListChainRollups(period, bucket, bucket.Add(period.duration()), 1)
+			if err != nil {
+				return count, err
+			}
+			if len(rollups) == 0 {
+				continue
+			}
+			if err := state.WriteRollup(period, bucket); err != nil {
+				return count, fmt.Errorf("failed to recompute %s rollup at %s: %v", period, bucket.Format(time.RFC3339), err)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
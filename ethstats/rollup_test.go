@@ -0,0 +1,101 @@
+package ethstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollupPeriod(t *testing.T) {
+	period, err := ParseRollupPeriod("day")
+	assert.NoError(t, err)
+	assert.Equal(t, period, RollupDaily)
+
+	_, err = ParseRollupPeriod("week")
+	assert.Error(t, err)
+
+	ts := time.Date(2022, 3, 4, 15, 30, 10, 0, time.UTC)
+	assert.Equal(t, RollupHourly.truncate(ts), time.Date(2022, 3, 4, 15, 0, 0, 0, time.UTC))
+	assert.Equal(t, RollupDaily.truncate(ts), time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC))
+}
+
+func TestRunRollups(t *testing.T) {
+	s := NewMemoryState()
+
+	now := time.Date(2022, 3, 4, 15, 30, 0, 0, time.UTC)
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 1, Hash: "0x1", Timestamp: int(now.Add(-2 * time.Hour).Unix())}))
+
+	// without previous rollups, the buckets are computed from the default backfill
+	count, err := RunRollups(s, now)
+	assert.NoError(t, err)
+	assert.Equal(t, count, int(defaultRollupBackfill/time.Hour)+int(defaultRollupBackfill/(24*time.Hour)))
+
+	last, err := s.LastRollup(RollupHourly)
+	assert.NoError(t, err)
+	assert.Equal(t, last, time.Date(2022, 3, 4, 14, 0, 0, 0, time.UTC))
+
+	last, err = s.LastRollup(RollupDaily)
+	assert.NoError(t, err)
+	assert.Equal(t, last, time.Date(2022, 3, 3, 0, 0, 0, 0, time.UTC))
+
+	rollups, err := s.ListChainRollups(RollupHourly, now.Add(-3*time.Hour), now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, rollups, 2)
+	assert.Equal(t, rollups[0].BlockCount, 0)
+	assert.Equal(t, rollups[1].BlockCount, 1)
+
+	// only the complete buckets since the last rollup are computed
+	count, err = RunRollups(s, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, count, 2)
+
+	count, err = RunRollups(s, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, count, 0)
+}
+
+func TestRecomputeRollups(t *testing.T) {
+	s := NewMemoryState()
+
+	now := time.Date(2022, 3, 4, 15, 30, 0, 0, time.UTC)
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 1, Hash: "0x1", Timestamp: int(now.Add(-80 * time.Minute).Unix())}))
+
+	_, err := RunRollups(s, now)
+	assert.NoError(t, err)
+
+	// a late block in the last computed hour and a block in the current one
+	stale := newStaleRollups()
+	for _, block := range []*Block{
+		{Number: 2, Hash: "0x2", Timestamp: int(now.Add(-70 * time.Minute).Unix())},
+		{Number: 3, Hash: "0x3", ParentHash: "0x2", Timestamp: int(now.Unix())},
+	} {
+		assert.NoError(t, s.WriteBlock(config, block))
+		stale.blockChanged(block.Timestamp)
+	}
+
+	// only the hour already computed is recomputed, the day is not complete yet
+	count, err := recomputeRollups(s, stale.take(), time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, count, 1)
+	assert.Empty(t, stale.take())
+
+	rollups, err := s.ListChainRollups(RollupHourly, now.Add(-2*time.Hour), now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, rollups, 1)
+	assert.Equal(t, rollups[0].BlockCount, 2)
+
+	// the blocks whose status changes mark their buckets as stale
+	c := newCanonicalChain(hclog.NewNullLogger(), s, 0, stale)
+	assert.NoError(t, c.addBlock(&Block{Number: 3, Hash: "0x3", ParentHash: "0x2"}))
+	assert.Equal(t, stale.take()[RollupHourly], []time.Time{
+		time.Date(2022, 3, 4, 14, 0, 0, 0, time.UTC),
+		time.Date(2022, 3, 4, 15, 0, 0, 0, time.UTC),
+	})
+
+	sibling := &Block{Number: 2, Hash: "0x2b", Timestamp: int(now.Add(-65 * time.Minute).Unix())}
+	assert.NoError(t, s.WriteBlock(config, sibling))
+	assert.NoError(t, c.addBlock(sibling))
+	assert.Equal(t, stale.take()[RollupHourly], []time.Time{time.Date(2022, 3, 4, 14, 0, 0, 0, time.UTC)})
+}
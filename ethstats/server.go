@@ -2,7 +2,12 @@ package ethstats
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	_ "github.com/lib/pq"
@@ -15,46 +20,183 @@ type Config struct {
 	FrontendAddr       string
 	FrontendSecret     string
 	ShouldSaveBlockTxs bool
+
+	// CredentialsFile is a json file with the secret of each node, the
+	// nodes not in the file are rejected (the CollectorSecret is not used)
+	CredentialsFile string
+
+	// CredentialsReloadInterval is how often the credentials file is checked for changes
+	CredentialsReloadInterval time.Duration
+
+	// AllowNodes are the only nodes accepted (if not empty)
+	AllowNodes []string
+
+	// DenyNodes are the nodes always rejected
+	DenyNodes []string
+
+	// SessionIdleTimeout closes the sessions of the nodes that do not
+	// send any message within the timeout (0 disables the timeout)
+	SessionIdleTimeout time.Duration
+
+	// AdminToken authenticates the requests to the admin api, the
+	// admin api is disabled if it is empty
+	AdminToken string
+
+	// Frontends are the upstream servers the messages are proxied to
+	// in addition to the one of FrontendAddr
+	Frontends []*Frontend
+
+	// ChainViewDepth is the number of blocks tracked per node to detect chain splits
+	ChainViewDepth int
+
+	// FinalityDepth is the number of blocks after which a canonical
+	// block is considered final (0 disables the finalization)
+	FinalityDepth int
+
+	// HistoryInterval is how often the missing blocks are requested
+	// to the nodes (0 disables the backfill)
+	HistoryInterval time.Duration
+
+	// HistoryLookback is the number of blocks below the highest block checked for gaps
+	HistoryLookback int
+
+	// RetentionInterval is how often the rows older than the
+	// retention of each table are deleted (0 disables the retention)
+	RetentionInterval time.Duration
+
+	// Retention is how long the rows of each table are kept
+	Retention RetentionConfig
+
+	// RetentionBatchSize is the number of rows deleted at once
+	RetentionBatchSize int
+
+	// Alerts are the health rules evaluated for the nodes
+	Alerts AlertConfig
+
+	// ShutdownTimeout is how long the server waits on Close for the nodes to close
+	// their sessions and for the writes in flight (defaultShutdownTimeout if 0)
+	ShutdownTimeout time.Duration
 }
 
+const (
+	defaultShutdownTimeout = 10 * time.Second
+
+	// shutdownForceTimeout is how long the server waits for the sessions
+	// once they are closed after the shutdown timeout
+	shutdownForceTimeout = time.Second
+)
+
 type Server struct {
 	logger hclog.Logger
-	config *Config
-	state  *State
-	srv    *http.Server
+
+	// configLock protects the fields of the config changed when it is reloaded
+	configLock sync.RWMutex
+	config     *Config
+
+	state     Store
+	srv       *http.Server
+	metrics   *metrics
+	chain     *chainView
+	canonical *canonicalChain
+	history   *historyBackfill
+	feed      *eventFeed
+	auth      *nodeAuth
+	collector *wsCollector
+	alerts    *alertEngine
+	rollups   *staleRollups
+	closeCh   chan struct{}
+
+	// tasks are the connections of the nodes and the background loops
+	// the server waits for on Close before the store is closed
+	tasks sync.WaitGroup
+
+	// sessions are the connected nodes indexed by id. No new
+	// sessions or tasks are started once the server is closing.
+	sessionsLock sync.Mutex
+	sessions     map[string]*wsSession
+	closing      bool
 }
 
 func NewServer(logger hclog.Logger, config *Config) (*Server, error) {
-	state, err := NewState(config.Endpoint)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	frontends, err := config.frontends()
 	if err != nil {
 		return nil, err
 	}
-	srv := &Server{
-		logger: logger,
-		config: config,
-		state:  state,
+	auth, err := newNodeAuth(config.CollectorSecret, config.CredentialsFile, config.AllowNodes, config.DenyNodes)
+	if err != nil {
+		return nil, err
+	}
+	state, err := NewStore(config.Endpoint)
+	if err != nil {
+		return nil, err
 	}
+	srv := newServer(logger, config, state)
+	srv.auth = auth
 
 	// start http/ws collector server
-	srv.startCollectorServer()
+	srv.startCollectorServer(frontends)
+
+	if config.HistoryInterval > 0 {
+		srv.runTask(srv.runHistoryBackfill)
+	}
+	if config.RetentionInterval > 0 {
+		srv.runTask(srv.runRetention)
+	}
+	// the credentials file can also be set when the config is reloaded
+	if config.CredentialsReloadInterval > 0 {
+		srv.runTask(srv.runCredentialsReload)
+	}
+	if config.Alerts.Interval > 0 && config.Alerts.Rules.enabled() {
+		srv.runTask(srv.runAlerts)
+	}
 
 	return srv, nil
 }
 
-func (s *Server) startCollectorServer() {
+// newServer creates the server over the store without starting the collector
+func newServer(logger hclog.Logger, config *Config, state Store) *Server {
+	metrics := newMetrics()
+
+	srv := &Server{
+		logger:   logger,
+		config:   config,
+		state:    metrics.instrumentStore(state),
+		metrics:  metrics,
+		chain:    newChainView(config.ChainViewDepth),
+		history:  newHistoryBackfill(),
+		rollups:  newStaleRollups(),
+		closeCh:  make(chan struct{}),
+		sessions: map[string]*wsSession{},
+	}
+	srv.canonical = newCanonicalChain(logger.Named("canonical"), srv.state, config.FinalityDepth, srv.rollups)
+	srv.feed = newEventFeed(logger.Named("feed"), metrics, srv.closeCh)
+	srv.alerts = newAlertEngine(logger.Named("alerts"), metrics, config.Alerts)
+	return srv
+}
+
+func (s *Server) startCollectorServer(frontends []*Frontend) {
 	collector := &wsCollector{
-		logger:      s.logger.Named("collector"),
-		manager:     s,
-		proxyAddr:   s.config.FrontendAddr,
-		proxySecret: s.config.FrontendSecret,
-		secret:      s.config.CollectorSecret,
+		logger:    s.logger.Named("collector"),
+		manager:   s,
+		frontends: frontends,
+		auth:      s.auth,
+		metrics:   s.metrics,
+
+		idleTimeout: s.config.SessionIdleTimeout,
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		upgrader.CheckOrigin = func(r *http.Request) bool {
-			return true
+		// the websocket connections are hijacked, so the http server does not wait for them
+		if !s.beginTask() {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
 		}
+		defer s.tasks.Done()
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			return
@@ -62,6 +204,30 @@ func (s *Server) startCollectorServer() {
 		collector.handle(conn)
 	})
 
+	// read-only json api
+	api := &apiHandler{
+		logger: s.logger.Named("api"),
+		state:  s.state,
+	}
+	api.register(mux)
+
+	// live feed of the ingested events
+	mux.Handle("/api/v1/feed", s.feed)
+
+	// activity of the connected nodes and state of their proxies
+	mux.HandleFunc("/api/v1/sessions", api.get(s.handleSessions))
+	mux.HandleFunc("/api/v1/proxy", api.get(s.handleProxyState))
+
+	// alerts firing for the nodes
+	mux.HandleFunc("/api/v1/alerts", api.get(s.handleAlerts))
+
+	// control requests to the connected nodes, rejected while there is no admin
+	// token (it is always mounted since the token can be set on reload)
+	mux.HandleFunc("/api/v1/admin/nodes/", api.post(s.handleNodeControl))
+
+	// prometheus metrics
+	mux.Handle("/metrics", s.metrics.handler())
+
 	srv := &http.Server{
 		Addr:    s.config.CollectorAddr,
 		Handler: mux,
@@ -73,10 +239,47 @@ func (s *Server) startCollectorServer() {
 		}
 	}()
 
+	s.collector = collector
+
 	s.logger.Info("Collector ws server started", "addr", s.config.CollectorAddr)
-	if s.config.FrontendAddr != "" {
-		s.logger.Info("Frontend downstream enabled", "addr", s.config.FrontendAddr)
+	for _, frontend := range frontends {
+		s.logger.Info("Frontend downstream enabled", "name", frontend.Name, "addr", frontend.Addr)
+	}
+}
+
+// handleProxyState returns the state of the proxies to the frontends of the
+// connected nodes (or only of the node in the 'node' parameter) sorted by node.
+// The requests have to be authenticated with the admin token once it is set.
+func (s *Server) handleProxyState(r *http.Request) (interface{}, error) {
+	if err := s.checkAdminToken(r, false); err != nil {
+		return nil, err
+	}
+
+	nodeID := r.URL.Query().Get("node")
+
+	s.sessionsLock.Lock()
+	sessions := []*wsSession{}
+	for _, session := range s.sessions {
+		if nodeID == "" || session.nodeID == nodeID {
+			sessions = append(sessions, session)
+		}
 	}
+	s.sessionsLock.Unlock()
+
+	if nodeID != "" && len(sessions) == 0 {
+		return nil, errNotFound("node '%s' not connected", nodeID)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].nodeID < sessions[j].nodeID
+	})
+
+	res := []*ProxyState{}
+	for _, session := range sessions {
+		for _, proxy := range session.getProxies() {
+			res = append(res, proxy.State())
+		}
+	}
+	return res, nil
 }
 
 func (s *Server) handleMessage(nodeID string, msg *Msg) {
@@ -90,42 +293,133 @@ func (s *Server) handleMessage(nodeID string, msg *Msg) {
 			if err := s.state.WriteNodeInfo(&info); err != nil {
 				return err
 			}
+			s.alerts.observeNode(nodeID)
 
 		case "block":
+			receivedAt := time.Now().UTC()
+
 			var block Block
 			if err := msg.decodeMsg("block", &block); err != nil {
 				return err
 			}
+			// the block is published by value since WriteBlock sets its default values
+			s.feed.publish(FeedTopicBlock, nodeID, block)
+
 			if err := s.state.WriteBlock(s.config, &block); err != nil {
 				return err
 			}
+			s.rollups.blockChanged(block.Timestamp)
+			s.metrics.updateNodeBlock(nodeID, block.Number)
+			s.alerts.observeBlock(nodeID, block.Number)
+
+			// every node reporting the block is recorded to measure the propagation
+			arrival := &BlockArrival{
+				NodeID:         nodeID,
+				BlockHash:      block.Hash,
+				BlockNumber:    block.Number,
+				BlockTimestamp: block.Timestamp,
+				ReceivedAt:     receivedAt,
+			}
+			if err := s.state.WriteBlockArrival(arrival); err != nil {
+				return err
+			}
+			s.metrics.blockArrived(arrival)
+
+			if err := s.canonical.addBlock(&block); err != nil {
+				return err
+			}
+
+			if err := s.handleChainSplits(s.chain.addBlock(nodeID, &block)); err != nil {
+				return err
+			}
 
 		case "stats":
 			var stats NodeStats
 			if err := msg.decodeMsg("stats", &stats); err != nil {
 				return err
 			}
+			s.feed.publish(FeedTopicStats, nodeID, stats)
+
 			if err := s.state.WriteNodeStats(nodeID, &stats); err != nil {
 				return err
 			}
+			s.metrics.updateNodeStats(nodeID, &stats)
+			s.alerts.observeStats(nodeID, &stats)
 
 		case "headEvent":
 			var event HeadEvent
 			if err := msg.decodeMsg("event", &event); err != nil {
 				return err
 			}
-			if _, err := s.state.WriteHeadEvent(nodeID, &event); err != nil {
+			s.feed.publish(FeedTopicHeadEvent, nodeID, event)
+
+			eventID, err := s.state.WriteHeadEvent(nodeID, &event)
+			if err != nil {
+				return err
+			}
+			if reorg := detectReorg(nodeID, eventID, &event); reorg != nil {
+				s.feed.publish(FeedTopicReorg, nodeID, reorg)
+
+				if err := s.state.WriteReorg(reorg); err != nil {
+					return err
+				}
+				s.logger.Info("reorg detected", "node", nodeID, "depth", reorg.Depth, "ancestor", reorg.CommonAncestorNumber)
+				s.metrics.reorgDetected(reorg)
+				s.alerts.observeReorg(reorg)
+			}
+			for _, added := range event.Added {
+				s.alerts.observeBlock(nodeID, added.Number)
+			}
+
+			if err := s.canonical.applyHeadEvent(&event); err != nil {
+				return err
+			}
+
+			if err := s.handleChainSplits(s.chain.applyHeadEvent(nodeID, &event)); err != nil {
 				return err
 			}
 
 		case "pending":
-			// TODO?
+			var stats struct {
+				Pending int `json:"pending"`
+			}
+			if err := msg.decodeMsg("stats", &stats); err != nil {
+				return err
+			}
+			pending := &NodePending{
+				NodeID:     nodeID,
+				Pending:    stats.Pending,
+				ReportedAt: time.Now().UTC(),
+			}
+			if err := s.state.WriteNodePending(pending); err != nil {
+				return err
+			}
+			s.metrics.updateNodePending(pending)
 
 		case "latency":
-			// we do not track latency
+			// the latency is reported either as a number or as a string
+			var latency json.Number
+			if err := msg.decodeMsg("latency", &latency); err != nil {
+				return err
+			}
+			ms, err := latency.Float64()
+			if err != nil {
+				return fmt.Errorf("failed to parse latency: %v", err)
+			}
+			nodeLatency := &NodeLatency{
+				NodeID:     nodeID,
+				Latency:    int(ms),
+				ReportedAt: time.Now().UTC(),
+			}
+			if err := s.state.WriteNodeLatency(nodeLatency); err != nil {
+				return err
+			}
+			s.metrics.updateNodeLatency(nodeLatency)
 
 		case "history":
-			// we do not use history
+			if err := s.handleHistory(msg); err != nil {
+				return err
+			}
 
 		default:
 			s.logger.Warn("unhandled message", "typ", msg.typ)
@@ -138,7 +432,172 @@ func (s *Server) handleMessage(nodeID string, msg *Msg) {
 	}
 }
 
+// handleChainSplits records the chain splits detected, changed or resolved by the chain view
+func (s *Server) handleChainSplits(splits []*ChainSplit) error {
+	for _, split := range splits {
+		s.feed.publish(FeedTopicSplit, "", split)
+
+		if err := s.state.WriteChainSplit(split); err != nil {
+			return err
+		}
+		if split.Resolved() {
+			s.logger.Info("chain split resolved", "id", split.ID, "height", split.Height)
+		} else {
+			s.logger.Warn("chain split detected", "id", split.ID, "height", split.Height, "fork", split.ForkNumber, "branches", split.Branches)
+		}
+	}
+	if len(splits) != 0 {
+		s.metrics.setActiveChainSplits(len(s.chain.activeSplits()))
+	}
+	return nil
+}
+
+// Reload applies the secrets, the node credentials and the frontends of the config to the
+// running server. The sessions of the nodes are kept, unless they cannot authenticate anymore,
+// and their proxies are updated to the new frontends. The other options require a restart,
+// their changes are not applied and logged as a warning.
+func (s *Server) Reload(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	frontends, err := config.frontends()
+	if err != nil {
+		return err
+	}
+	auth, err := newNodeAuth(config.CollectorSecret, config.CredentialsFile, config.AllowNodes, config.DenyNodes)
+	if err != nil {
+		return err
+	}
+
+	s.configLock.Lock()
+	restart := s.config.restartOptions(config)
+	s.config.CollectorSecret = config.CollectorSecret
+	s.config.CredentialsFile = config.CredentialsFile
+	s.config.AllowNodes = config.AllowNodes
+	s.config.DenyNodes = config.DenyNodes
+	s.config.AdminToken = config.AdminToken
+	s.config.FrontendAddr = config.FrontendAddr
+	s.config.FrontendSecret = config.FrontendSecret
+	s.config.Frontends = config.Frontends
+	s.configLock.Unlock()
+
+	if len(restart) != 0 {
+		s.logger.Warn("config options changed that require a restart, not applied", "options", restart)
+	}
+
+	s.auth.replace(auth)
+	s.revokeSessions()
+
+	s.collector.setFrontends(frontends)
+
+	sessions := s.activeSessions()
+	for _, session := range sessions {
+		s.collector.syncProxies(session)
+	}
+
+	s.logger.Info("config reloaded", "frontends", len(frontends), "sessions", len(sessions))
+	return nil
+}
+
+// beginTask registers a task the server waits for on Close, it
+// returns false if the server is closing and the task cannot start
+func (s *Server) beginTask() bool {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.tasks.Add(1)
+	return true
+}
+
+// runTask runs the function in the background as a task of the server
+func (s *Server) runTask(fn func()) {
+	if !s.beginTask() {
+		return
+	}
+	go func() {
+		defer s.tasks.Done()
+		fn()
+	}()
+}
+
+// waitTasks waits for the tasks of the server until the context is done
+func (s *Server) waitTasks(ctx context.Context) bool {
+	doneCh := make(chan struct{})
+	go func() {
+		s.tasks.Wait()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// activeSessions returns the sessions of the connected nodes
+func (s *Server) activeSessions() []*wsSession {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	sessions := []*wsSession{}
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Close shuts down the server in order: it stops accepting connections and the background
+// loops, asks the nodes to close their sessions (which also stops their proxies once the
+// queued messages are sent), waits for the messages being handled up to the shutdown
+// timeout and finally closes the store. The sessions still open after the timeout are closed.
 func (s *Server) Close() {
-	s.state.db.Close()
-	s.srv.Shutdown(context.Background())
+	timeout := s.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.sessionsLock.Lock()
+	s.closing = true
+	s.sessionsLock.Unlock()
+
+	// the live feed streams are also closed with the close channel
+	close(s.closeCh)
+	if s.srv != nil {
+		if err := s.srv.Shutdown(ctx); err != nil {
+			s.logger.Error("failed to shutdown the http server", "err", err)
+		}
+	}
+
+	sessions := s.activeSessions()
+	s.logger.Info("closing sessions", "sessions", len(sessions))
+	for _, session := range sessions {
+		if err := session.goAway(SessionReasonShutdown); err != nil {
+			s.logger.Debug("failed to send close to node", "node", session.nodeID, "err", err)
+			session.close(SessionReasonShutdown)
+		}
+	}
+
+	if !s.waitTasks(ctx) {
+		sessions := s.activeSessions()
+		s.logger.Warn("shutdown timeout, closing the remaining sessions", "sessions", len(sessions))
+		for _, session := range sessions {
+			session.close(SessionReasonShutdown)
+		}
+
+		forceCtx, forceCancel := context.WithTimeout(context.Background(), shutdownForceTimeout)
+		defer forceCancel()
+		if !s.waitTasks(forceCtx) {
+			s.logger.Error("closing the store before all the sessions finished")
+		}
+	}
+
+	s.state.Close()
+	s.logger.Info("server closed")
 }
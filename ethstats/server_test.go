@@ -0,0 +1,283 @@
+package ethstats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) *Server {
+	return newServer(hclog.NewNullLogger(), &Config{ShouldSaveBlockTxs: true}, NewMemoryState())
+}
+
+func mustDecodeMsg(t *testing.T, typ, data string) *Msg {
+	msg, err := DecodeMsg([]byte(`{"emit": ["` + typ + `", ` + data + `]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestServer_HandleMessage(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{
+		"info": {"name": "a", "node": "bor"}
+	}`))
+	info, err := srv.state.GetNodeInfo("a")
+	assert.NoError(t, err)
+	assert.Equal(t, info.Node, "bor")
+
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{
+		"block": {"number": 10, "hash": "0x10", "transactions": [{"hash": "0x1"}]}
+	}`))
+	block, err := srv.state.GetBlock("0x10")
+	assert.NoError(t, err)
+	assert.Equal(t, block.Number, 10)
+	assert.Len(t, block.Txs, 1)
+
+	srv.handleMessage("a", mustDecodeMsg(t, "stats", `{
+		"stats": {"active": true, "peers": 5}
+	}`))
+	stats, err := srv.state.GetNodeStats("a")
+	assert.NoError(t, err)
+	assert.Equal(t, stats, &NodeStats{Active: true, Peers: 5})
+}
+
+func TestServer_HandleReorg(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "headEvent", `{
+		"event": {
+			"type": "reorg",
+			"added": [{"hash": "0x2b", "parent_hash": "0x1", "number": 2}],
+			"removed": [{"hash": "0x2a", "parent_hash": "0x1", "number": 2}]
+		}
+	}`))
+
+	reorgs, err := srv.state.ListReorgs("a", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, reorgs, 1)
+	assert.Equal(t, reorgs[0].CommonAncestorHash, "0x1")
+
+	// a head event without removed blocks is not a reorg
+	srv.handleMessage("a", mustDecodeMsg(t, "headEvent", `{
+		"event": {
+			"type": "head",
+			"added": [{"hash": "0x3", "parent_hash": "0x2b", "number": 3}]
+		}
+	}`))
+
+	reorgs, err = srv.state.ListReorgs("a", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, reorgs, 1)
+}
+
+func TestServer_HandleChainSplit(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, node := range []string{"a", "b"} {
+		srv.handleMessage(node, mustDecodeMsg(t, "hello", `{"info": {"name": "`+node+`"}}`))
+		srv.handleMessage(node, mustDecodeMsg(t, "block", `{"block": {"number": 1, "hash": "0x1"}}`))
+	}
+	srv.handleMessage("a", mustDecodeMsg(t, "block", `{"block": {"number": 2, "hash": "0x2a", "parentHash": "0x1"}}`))
+	srv.handleMessage("b", mustDecodeMsg(t, "block", `{"block": {"number": 2, "hash": "0x2b", "parentHash": "0x1"}}`))
+
+	splits, err := srv.state.ListChainSplits("", 10)
+	assert.NoError(t, err)
+	assert.Len(t, splits, 1)
+	assert.Equal(t, splits[0].ForkHash, "0x1")
+	assert.False(t, splits[0].Resolved())
+}
+
+func TestServer_HandleBlockArrivals(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, node := range []string{"a", "b"} {
+		srv.handleMessage(node, mustDecodeMsg(t, "hello", `{"info": {"name": "`+node+`"}}`))
+		srv.handleMessage(node, mustDecodeMsg(t, "block", `{"block": {"number": 1, "hash": "0x1", "timestamp": 100}}`))
+	}
+
+	// the block is stored once but each node arrival is recorded
+	blocks, err := srv.state.ListBlocks(0, 10, 10)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 1)
+
+	arrivals, err := srv.state.GetBlockArrivals("0x1")
+	assert.NoError(t, err)
+	assert.Len(t, arrivals, 2)
+	assert.Equal(t, arrivals[0].NodeID, "a")
+	assert.Equal(t, arrivals[0].BlockTimestamp, 100)
+}
+
+func TestServer_HandleLatency(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a"}}`))
+
+	// geth reports the latency as a string
+	srv.handleMessage("a", mustDecodeMsg(t, "latency", `{"id": "a", "latency": "25"}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "latency", `{"id": "a", "latency": 30}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "latency", `{"id": "a", "latency": "bad"}`))
+
+	latencies, err := srv.state.ListNodeLatency("a", time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, latencies, 2)
+
+	values := []int{latencies[0].Latency, latencies[1].Latency}
+	assert.ElementsMatch(t, values, []int{25, 30})
+}
+
+func TestServer_HandlePending(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "pending", `{"id": "a", "stats": {"pending": 120}}`))
+
+	pending, err := srv.state.GetNodePending("a")
+	assert.NoError(t, err)
+	assert.Equal(t, pending.Pending, 120)
+}
+
+func TestServer_ProxyState(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, nodeID := range []string{"b", "a"} {
+		session := newWsSession(nodeID, &mockWsWriter{})
+		proxy := newWsProxy(nil, session, "ws://localhost:1")
+		proxy.nodeID = nodeID
+		proxy.frontend = "public"
+		session.proxies = []*wsProxy{proxy}
+		srv.sessionStarted(session)
+	}
+
+	res, err := srv.handleProxyState(httptest.NewRequest(http.MethodGet, "/api/v1/proxy", nil))
+	assert.NoError(t, err)
+
+	states := res.([]*ProxyState)
+	assert.Len(t, states, 2)
+	assert.Equal(t, states[0].NodeID, "a")
+	assert.Equal(t, states[1].NodeID, "b")
+
+	res, err = srv.handleProxyState(httptest.NewRequest(http.MethodGet, "/api/v1/proxy?node=b", nil))
+	assert.NoError(t, err)
+	assert.Len(t, res.([]*ProxyState), 1)
+
+	_, err = srv.handleProxyState(httptest.NewRequest(http.MethodGet, "/api/v1/proxy?node=c", nil))
+	assert.Error(t, err)
+
+	// the frontend addresses are only shown with the admin token once it is set
+	srv.config.AdminToken = "token"
+	_, err = srv.handleProxyState(httptest.NewRequest(http.MethodGet, "/api/v1/proxy", nil))
+	assert.Error(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/proxy", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	res, err = srv.handleProxyState(r)
+	assert.NoError(t, err)
+	assert.Len(t, res.([]*ProxyState), 2)
+}
+
+func TestServer_Close(t *testing.T) {
+	// the frontend records the messages proxied and how the proxy closed the connection
+	recvCh := make(chan []byte, 10)
+	closeErrCh := make(chan error, 1)
+	frontend := newMockWsServer(t, "", func(ctx context.Context, conn *websocket.Conn) {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				closeErrCh <- err
+				return
+			}
+			recvCh <- msg
+		}
+	})
+	defer frontend.close()
+
+	addr := "127.0.0.1:" + strconv.Itoa(getNextPort())
+	srv, err := NewServer(hclog.NewNullLogger(), &Config{
+		Endpoint:        "memory://",
+		CollectorAddr:   addr,
+		Frontends:       []*Frontend{{Name: "frontend", Addr: frontend.addr}},
+		ShutdownTimeout: 5 * time.Second,
+	})
+	assert.NoError(t, err)
+	time.Sleep(500 * time.Millisecond)
+
+	clt := newMockWsClient(t, "ws://"+addr)
+	clt.emit("hello", `{"secret": "", "info": {"name": "a"}}`)
+	clt.read()
+
+	select {
+	case <-recvCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	closedCh := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(closedCh)
+	}()
+
+	// the node is asked to close the session and replies to the close frame
+	_, _, err = clt.conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+
+	select {
+	case <-closedCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("close timeout")
+	}
+
+	// the proxy closed the connection with the frontend
+	select {
+	case err := <-closeErrCh:
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	// the disconnection was written before the store was closed
+	events, err := srv.state.ListSessionEvents("a", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, events[0].Type, SessionDisconnected)
+	assert.Equal(t, events[0].Reason, SessionReasonShutdown)
+
+	_, _, err = websocket.DefaultDialer.Dial("ws://"+addr, nil)
+	assert.Error(t, err)
+}
+
+func TestServer_CloseTimeout(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(getNextPort())
+	srv, err := NewServer(hclog.NewNullLogger(), &Config{
+		Endpoint:        "memory://",
+		CollectorAddr:   addr,
+		ShutdownTimeout: 200 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	time.Sleep(500 * time.Millisecond)
+
+	clt := newMockWsClient(t, "ws://"+addr)
+	clt.emit("hello", `{"secret": "", "info": {"name": "a"}}`)
+	clt.read()
+
+	// the node does not read the close frame, so its session is closed after the timeout
+	start := time.Now()
+	srv.Close()
+	assert.Less(t, int64(time.Since(start)), int64(shutdownForceTimeout+time.Second))
+
+	events, err := srv.state.ListSessionEvents("a", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, events[0].Reason, SessionReasonShutdown)
+}
@@ -0,0 +1,145 @@
+package ethstats
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	SessionConnected    = "connected"
+	SessionDisconnected = "disconnected"
+)
+
+const (
+	// SessionReasonClosed is the reason of the sessions closed by the node (or a network error)
+	SessionReasonClosed = "closed"
+
+	// SessionReasonIdle is the reason of the sessions closed because the node did not send messages
+	SessionReasonIdle = "idle"
+
+	// SessionReasonRevoked is the reason of the sessions closed because the credentials of the node were revoked
+	SessionReasonRevoked = "revoked"
+
+	// SessionReasonAdmin is the reason of the sessions closed with the admin api
+	SessionReasonAdmin = "admin"
+
+	// SessionReasonShutdown is the reason of the sessions closed because the server shut down
+	SessionReasonShutdown = "shutdown"
+)
+
+// SessionEvent is the connection or disconnection of a node to the collector
+type SessionEvent struct {
+	ID         string `json:"id" db:"event_id"`
+	NodeID     string `json:"node" db:"node_id"`
+	Type       string `json:"type" db:"type"`
+	RemoteAddr string `json:"remoteAddr" db:"remote_addr"`
+
+	// Reason and Messages are the reason of the disconnection and
+	// the messages received during the session (only when disconnected)
+	Reason   string `json:"reason,omitempty" db:"reason"`
+	Messages int    `json:"messages" db:"messages"`
+
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// SessionInfo is the activity of a connected node
+type SessionInfo struct {
+	NodeID        string     `json:"node"`
+	RemoteAddr    string     `json:"remoteAddr"`
+	ConnectedAt   time.Time  `json:"connectedAt"`
+	LastMessageAt *time.Time `json:"lastMessageAt,omitempty"`
+
+	// Messages is the number of messages received by type
+	Messages map[string]int `json:"messages"`
+}
+
+func (s *Server) sessionStarted(session *wsSession) error {
+	s.sessionsLock.Lock()
+	if s.closing {
+		s.sessionsLock.Unlock()
+		return fmt.Errorf("server is shutting down")
+	}
+	if _, ok := s.sessions[session.nodeID]; ok {
+		s.sessionsLock.Unlock()
+		return fmt.Errorf("node '%s' is already connected", session.nodeID)
+	}
+	s.sessions[session.nodeID] = session
+	s.sessionsLock.Unlock()
+
+	s.writeSessionEvent(session, SessionConnected)
+	return nil
+}
+
+func (s *Server) sessionClosed(session *wsSession) {
+	s.sessionsLock.Lock()
+	current := s.sessions[session.nodeID] == session
+	if current {
+		delete(s.sessions, session.nodeID)
+		s.chain.removeNode(session.nodeID)
+	}
+	s.sessionsLock.Unlock()
+
+	if !current {
+		return
+	}
+
+	// the node is offline until it connects again, whatever its latest stats say
+	if err := s.state.SetNodeActive(session.nodeID, false); err != nil {
+		s.logger.Error("failed to mark node offline", "node", session.nodeID, "err", err)
+	}
+	s.metrics.nodeOffline(session.nodeID)
+
+	// the rules are still evaluated with the latest data of the node
+	s.alerts.disconnectNode(session.nodeID, session.closeReason(), time.Now())
+
+	s.writeSessionEvent(session, SessionDisconnected)
+}
+
+// writeSessionEvent persists the connection or disconnection of the session
+func (s *Server) writeSessionEvent(session *wsSession, typ string) {
+	id, err := newUlid()
+	if err != nil {
+		s.logger.Error("failed to create session event id", "err", err)
+		return
+	}
+	info := session.info()
+
+	evnt := &SessionEvent{
+		ID:         id,
+		NodeID:     session.nodeID,
+		Type:       typ,
+		RemoteAddr: info.RemoteAddr,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if typ == SessionDisconnected {
+		evnt.Reason = session.closeReason()
+		for _, num := range info.Messages {
+			evnt.Messages += num
+		}
+	}
+	if err := s.state.WriteSessionEvent(evnt); err != nil {
+		s.logger.Error("failed to write session event", "node", session.nodeID, "type", typ, "err", err)
+	}
+}
+
+// handleSessions returns the activity of the connected nodes sorted by node. The
+// requests have to be authenticated with the admin token once it is set.
+func (s *Server) handleSessions(r *http.Request) (interface{}, error) {
+	if err := s.checkAdminToken(r, false); err != nil {
+		return nil, err
+	}
+
+	s.sessionsLock.Lock()
+	sessions := []*SessionInfo{}
+	for _, session := range s.sessions {
+		sessions = append(sessions, session.info())
+	}
+	s.sessionsLock.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].NodeID < sessions[j].NodeID
+	})
+	return sessions, nil
+}
@@ -0,0 +1,115 @@
+package ethstats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Sessions(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.handleMessage("a", mustDecodeMsg(t, "hello", `{"info": {"name": "a"}}`))
+	srv.handleMessage("a", mustDecodeMsg(t, "stats", `{"stats": {"active": true, "peers": 5}}`))
+
+	session := newWsSession("a", &mockWsWriter{})
+	session.remoteAddr = "127.0.0.1:3000"
+	assert.NoError(t, srv.sessionStarted(session))
+
+	// a second session of the node is rejected
+	assert.Error(t, srv.sessionStarted(newWsSession("a", &mockWsWriter{})))
+
+	session.received("hello")
+	session.received("stats")
+	session.received("stats")
+
+	res, err := srv.handleSessions(httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil))
+	assert.NoError(t, err)
+
+	sessions := res.([]*SessionInfo)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, sessions[0].RemoteAddr, "127.0.0.1:3000")
+	assert.Equal(t, sessions[0].Messages, map[string]int{"hello": 1, "stats": 2})
+	assert.NotNil(t, sessions[0].LastMessageAt)
+
+	session.setCloseReason(SessionReasonIdle)
+	srv.sessionClosed(session)
+
+	// the node is offline once its session is closed
+	stats, err := srv.state.GetNodeStats("a")
+	assert.NoError(t, err)
+	assert.False(t, stats.Active)
+	assert.Equal(t, stats.Peers, 5)
+
+	events, err := srv.state.ListSessionEvents("a", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, events[0].Type, SessionDisconnected)
+	assert.Equal(t, events[0].Reason, SessionReasonIdle)
+	assert.Equal(t, events[0].Messages, 3)
+	assert.Equal(t, events[1].Type, SessionConnected)
+	assert.Equal(t, events[1].RemoteAddr, "127.0.0.1:3000")
+
+	res, err = srv.handleSessions(httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil))
+	assert.NoError(t, err)
+	assert.Len(t, res.([]*SessionInfo), 0)
+}
+
+func TestServer_SessionsAdminToken(t *testing.T) {
+	srv := newTestServer(t)
+	srv.config.AdminToken = "token"
+
+	assert.NoError(t, srv.sessionStarted(newWsSession("a", &mockWsWriter{})))
+
+	// the remote addresses are only shown with the admin token once it is set
+	_, err := srv.handleSessions(httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil))
+	assert.Error(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	res, err := srv.handleSessions(r)
+	assert.NoError(t, err)
+	assert.Len(t, res.([]*SessionInfo), 1)
+}
+
+func TestWsCollector_IdleTimeout(t *testing.T) {
+	srv := newTestServer(t)
+
+	ws := &wsCollector{
+		manager:     srv,
+		logger:      hclog.NewNullLogger(),
+		idleTimeout: 200 * time.Millisecond,
+	}
+	collector := newMockWsServer(t, "", func(ctx context.Context, conn *websocket.Conn) {
+		ws.handle(conn)
+	})
+	defer collector.close()
+
+	clt := newMockWsClient(t, collector.addr)
+	clt.emit("hello", `{
+		"secret": "",
+		"info": {"name": "a"}
+	}`)
+	clt.read()
+
+	// the silent node is disconnected
+	_, _, err := clt.conn.ReadMessage()
+	assert.Error(t, err)
+
+	var events []*SessionEvent
+	for i := 0; i < 100; i++ {
+		if events, err = srv.state.ListSessionEvents("a", "", 10); err == nil && len(events) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, events, 2)
+	assert.Equal(t, events[0].Reason, SessionReasonIdle)
+	assert.Equal(t, events[0].Messages, 1)
+}
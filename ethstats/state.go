@@ -3,21 +3,20 @@ package ethstats
 import (
 	"crypto/rand"
 	"database/sql"
-	"embed"
 	"fmt"
-	"io/fs"
 	"math/big"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/oklog/ulid"
 )
 
-//go:embed migrations/*.sql
-var migrations embed.FS
-
+// State is the Store backed by a sql database (Postgres or SQLite)
 type State struct {
-	db *sqlx.DB
+	db      *sqlx.DB
+	dialect *dialect
 }
 
 func NewState(path string) (*State, error) {
@@ -29,12 +28,16 @@ func NewState(path string) (*State, error) {
 }
 
 func NewStateWithDB(db *sqlx.DB) (*State, error) {
-	err := db.Ping()
+	dialect, err := dialectForDriver(db.DriverName())
 	if err != nil {
 		return nil, err
 	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
 	s := &State{
-		db: db,
+		db:      db,
+		dialect: dialect,
 	}
 	if err := s.migrate(); err != nil {
 		return nil, err
@@ -47,26 +50,11 @@ func (s *State) Close() {
 }
 
 func (s *State) migrate() error {
-	tx, err := s.db.Begin()
-	if err != nil {
-		return err
-	}
-
-	sqlMigrations, err := fs.ReadDir(migrations, "migrations")
+	migrator, err := newMigrator(s.db, s.dialect)
 	if err != nil {
 		return err
 	}
-	for _, sqlExec := range sqlMigrations {
-		sqlTableQuery, err := fs.ReadFile(migrations, "migrations/"+sqlExec.Name())
-		if err != nil {
-			return err
-		}
-		if _, err = tx.Exec(string(sqlTableQuery)); err != nil {
-			return fmt.Errorf("failed to migrate sql %s: %v", sqlExec.Name(), err)
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
+	if _, err := migrator.Up(0); err != nil {
 		return err
 	}
 	return nil
@@ -75,7 +63,7 @@ func (s *State) migrate() error {
 func (s *State) GetBlock(hash string) (*Block, error) {
 	block := Block{}
 
-	query := "SELECT number, hash, parent_hash, timestamp, miner, gas_used, gas_limit, difficulty, total_difficulty, transactions_root, state_root FROM blocks WHERE hash=$1"
+	query := "SELECT number, hash, parent_hash, timestamp, miner, gas_used, gas_limit, difficulty, total_difficulty, transactions_root, state_root, status, finalized FROM blocks WHERE hash=$1"
 	if err := s.db.Get(&block, query, hash); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -144,6 +132,90 @@ func (s *State) WriteBlock(config *Config, b *Block) error {
 	return nil
 }
 
+func (s *State) ListBlocks(from, to, limit int) ([]*Block, error) {
+	blocks := []*Block{}
+
+	query := `SELECT number, hash, parent_hash, timestamp, miner, gas_used, gas_limit, difficulty, total_difficulty, transactions_root, state_root, status, finalized FROM blocks
+		WHERE number >= $1 AND number <= $2 ORDER BY number DESC, hash LIMIT $3`
+	if err := s.db.Select(&blocks, query, from, to, limit); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func (s *State) ListBlockNumbers(from, to int) ([]int, error) {
+	numbers := []int{}
+	if err := s.db.Select(&numbers, "SELECT DISTINCT number FROM blocks WHERE number >= $1 AND number <= $2 ORDER BY number", from, to); err != nil {
+		return nil, err
+	}
+	return numbers, nil
+}
+
+func (s *State) SetBlockStatus(hash string, status string) error {
+	if _, err := s.db.Exec("UPDATE blocks SET status = $1 WHERE hash = $2 AND finalized = false", status, hash); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) GetCanonicalBlock(number int) (*Block, error) {
+	return s.getCanonicalBlock("SELECT hash FROM blocks WHERE number = $1 AND status = $2 LIMIT 1", number, BlockStatusCanonical)
+}
+
+func (s *State) GetCanonicalHead() (*Block, error) {
+	return s.getCanonicalBlock("SELECT hash FROM blocks WHERE status = $1 ORDER BY number DESC LIMIT 1", BlockStatusCanonical)
+}
+
+func (s *State) getCanonicalBlock(query string, args ...interface{}) (*Block, error) {
+	var hash string
+	if err := s.db.Get(&hash, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.GetBlock(hash)
+}
+
+func (s *State) FinalizeBlocks(number int) error {
+	query := "UPDATE blocks SET finalized = true WHERE number <= $1 AND status = $2 AND finalized = false"
+	if _, err := s.db.Exec(query, number, BlockStatusCanonical); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) WriteBlockArrival(arrival *BlockArrival) error {
+	query := `INSERT INTO block_arrivals
+		("node_id", "block_hash", "block_number", "block_timestamp", "received_at")
+		VALUES (:node_id, :block_hash, :block_number, :block_timestamp, :received_at)
+		ON CONFLICT DO NOTHING`
+
+	if _, err := s.db.NamedExec(query, arrival); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) GetBlockArrivals(hash string) ([]*BlockArrival, error) {
+	arrivals := []*BlockArrival{}
+	if err := s.db.Select(&arrivals, "SELECT * FROM block_arrivals WHERE block_hash = $1 ORDER BY received_at, node_id", hash); err != nil {
+		return nil, err
+	}
+	return arrivals, nil
+}
+
+func (s *State) ListBlockArrivals(from, to int) ([]*BlockArrival, error) {
+	query := `SELECT * FROM block_arrivals WHERE block_number >= $1 AND block_number <= $2
+		ORDER BY block_number, block_hash, received_at, node_id`
+
+	arrivals := []*BlockArrival{}
+	if err := s.db.Select(&arrivals, query, from, to); err != nil {
+		return nil, err
+	}
+	return arrivals, nil
+}
+
 func (s *State) GetNodeInfo(nodeID string) (*NodeInfo, error) {
 	info := NodeInfo{}
 	if err := s.db.Get(&info, "SELECT * FROM nodeinfo WHERE node_id=$1", nodeID); err != nil {
@@ -165,6 +237,7 @@ func (s *State) WriteNodeInfo(nodeInfo *NodeInfo) error {
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
 	var count int
 	row := tx.QueryRow(fmt.Sprintf(`SELECT count(*) FROM nodeinfo Where node_id='%s'`, nodeID))
@@ -199,6 +272,14 @@ func (s *State) WriteNodeInfo(nodeInfo *NodeInfo) error {
 	return nil
 }
 
+func (s *State) ListNodes() ([]*NodeInfo, error) {
+	nodes := []*NodeInfo{}
+	if err := s.db.Select(&nodes, "SELECT * FROM nodeinfo ORDER BY node_id"); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
 func (s *State) GetNodeStats(nodeID string) (*NodeStats, error) {
 	stats := NodeStats{}
 	if err := s.db.Get(&stats, "SELECT active, syncing, mining, hashrate, peers, gasprice, uptime FROM nodestats WHERE node_id=$1", nodeID); err != nil {
@@ -211,28 +292,306 @@ func (s *State) GetNodeStats(nodeID string) (*NodeStats, error) {
 }
 
 func (s *State) WriteNodeStats(nodeId string, stats *NodeStats) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `UPDATE nodestats SET active = $1, syncing = $2, mining = $3, hashrate = $4, peers = $5, gasprice = $6, uptime = $7, updated_at = $8
 	WHERE node_id=$9;`
 
-	if _, err := s.db.Exec(query, stats.Active, stats.Syncing, stats.Mining, stats.Hashrate, stats.Peers, stats.GasPrice, stats.Uptime, time.Now(), nodeId); err != nil {
+	res, err := tx.Exec(query, stats.Active, stats.Syncing, stats.Mining, stats.Hashrate, stats.Peers, stats.GasPrice, stats.Uptime, time.Now(), nodeId)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		// unknown node
+		return nil
+	}
+
+	query = `INSERT INTO nodestats_history ("node_id", "active", "syncing", "mining", "hashrate", "peers", "gasprice", "uptime", "reported_at")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	if _, err := tx.Exec(query, nodeId, stats.Active, stats.Syncing, stats.Mining, stats.Hashrate, stats.Peers, stats.GasPrice, stats.Uptime, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) SetNodeActive(nodeID string, active bool) error {
+	if _, err := s.db.Exec("UPDATE nodestats SET active = $1 WHERE node_id = $2", active, nodeID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) GetNodeStatsAt(nodeID string, at time.Time) (*NodeStatsSnapshot, error) {
+	snapshot := NodeStatsSnapshot{}
+
+	query := "SELECT * FROM nodestats_history WHERE node_id = $1 AND reported_at <= $2 ORDER BY reported_at DESC LIMIT 1"
+	if err := s.db.Get(&snapshot, query, nodeID, at.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (s *State) ListNodeStatsHistory(nodeID string, from, to time.Time, limit int) ([]*NodeStatsSnapshot, error) {
+	conds := []string{"node_id = $1"}
+	args := []interface{}{nodeID}
+	if !from.IsZero() {
+		args = append(args, from.UTC())
+		conds = append(conds, fmt.Sprintf("reported_at >= $%d", len(args)))
+	}
+	if !to.IsZero() {
+		args = append(args, to.UTC())
+		conds = append(conds, fmt.Sprintf("reported_at <= $%d", len(args)))
+	}
+	args = append(args, limit)
+	query := "SELECT * FROM nodestats_history WHERE " + strings.Join(conds, " AND ") + fmt.Sprintf(" ORDER BY reported_at LIMIT $%d", len(args))
+
+	history := []*NodeStatsSnapshot{}
+	if err := s.db.Select(&history, query, args...); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (s *State) WriteNodeLatency(latency *NodeLatency) error {
+	query := `INSERT INTO node_latency ("node_id", "latency", "reported_at") VALUES (:node_id, :latency, :reported_at)`
+
+	if _, err := s.db.NamedExec(query, latency); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) ListNodeLatency(nodeID string, since time.Time, limit int) ([]*NodeLatency, error) {
+	latencies := []*NodeLatency{}
+
+	var err error
+	if !since.IsZero() {
+		err = s.db.Select(&latencies, "SELECT * FROM node_latency WHERE node_id = $1 AND reported_at >= $2 ORDER BY reported_at DESC LIMIT $3", nodeID, since.UTC(), limit)
+	} else {
+		err = s.db.Select(&latencies, "SELECT * FROM node_latency WHERE node_id = $1 ORDER BY reported_at DESC LIMIT $2", nodeID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return latencies, nil
+}
+
+func (s *State) WriteNodePending(pending *NodePending) error {
+	query := `INSERT INTO node_pending ("node_id", "pending", "reported_at") VALUES (:node_id, :pending, :reported_at)`
+
+	if _, err := s.db.NamedExec(query, pending); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) GetNodePending(nodeID string) (*NodePending, error) {
+	pending := NodePending{}
+	if err := s.db.Get(&pending, "SELECT * FROM node_pending WHERE node_id = $1 ORDER BY reported_at DESC LIMIT 1", nodeID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &pending, nil
+}
+
+func (s *State) ListNodePending(nodeID string, since time.Time, limit int) ([]*NodePending, error) {
+	pending := []*NodePending{}
+
+	var err error
+	if !since.IsZero() {
+		err = s.db.Select(&pending, "SELECT * FROM node_pending WHERE node_id = $1 AND reported_at >= $2 ORDER BY reported_at DESC LIMIT $3", nodeID, since.UTC(), limit)
+	} else {
+		err = s.db.Select(&pending, "SELECT * FROM node_pending WHERE node_id = $1 ORDER BY reported_at DESC LIMIT $2", nodeID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (s *State) WriteRollup(period RollupPeriod, bucket time.Time) error {
+	start := period.truncate(bucket)
+	end := start.Add(period.duration())
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the orphaned blocks and the uncles are not part of the chain
+	var blocks struct {
+		Count        int     `db:"block_count"`
+		AvgGasRatio  float64 `db:"avg_gas_ratio"`
+		MinTimestamp int64   `db:"min_timestamp"`
+		MaxTimestamp int64   `db:"max_timestamp"`
+	}
+	query := `SELECT count(*) AS block_count,
+		COALESCE(avg(CASE WHEN gas_limit > 0 THEN CAST(gas_used AS double precision) / gas_limit END), 0) AS avg_gas_ratio,
+		COALESCE(CAST(min(timestamp) AS bigint), 0) AS min_timestamp,
+		COALESCE(CAST(max(timestamp) AS bigint), 0) AS max_timestamp
+		FROM blocks WHERE timestamp >= $1 AND timestamp < $2 AND status NOT IN ($3, $4)`
+
+	if err := tx.Get(&blocks, query, start.Unix(), end.Unix(), BlockStatusOrphaned, BlockStatusUncle); err != nil {
+		return err
+	}
+
+	var reorgs int
+	if err := tx.Get(&reorgs, "SELECT count(*) FROM reorgs WHERE detected_at >= $1 AND detected_at < $2", start, end); err != nil {
+		return err
+	}
+
+	chain := &ChainRollup{
+		Period:       period,
+		Bucket:       start,
+		BlockCount:   blocks.Count,
+		AvgGasRatio:  blocks.AvgGasRatio,
+		AvgBlockTime: avgBlockTime(blocks.Count, blocks.MinTimestamp, blocks.MaxTimestamp),
+		ReorgCount:   reorgs,
+	}
+	query = `INSERT INTO chain_rollups ("period", "bucket", "block_count", "avg_gas_ratio", "avg_block_time", "reorg_count")
+		VALUES (:period, :bucket, :block_count, :avg_gas_ratio, :avg_block_time, :reorg_count)
+		ON CONFLICT (period, bucket) DO UPDATE SET block_count = excluded.block_count, avg_gas_ratio = excluded.avg_gas_ratio,
+		avg_block_time = excluded.avg_block_time, reorg_count = excluded.reorg_count`
+
+	if _, err := tx.NamedExec(query, chain); err != nil {
+		return err
+	}
+
+	nodes := []*NodeRollup{}
+	query = `SELECT node_id, count(*) AS samples,
+		COALESCE(avg(CAST(peers AS double precision)), 0) AS avg_peers,
+		COALESCE(avg(CAST(uptime AS double precision)), 0) AS avg_uptime,
+		COALESCE(avg(CASE WHEN active THEN 1.0 ELSE 0.0 END), 0) AS active_ratio
+		FROM nodestats_history WHERE reported_at >= $1 AND reported_at < $2 GROUP BY node_id`
+
+	if err := tx.Select(&nodes, query, start, end); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		node.Period = period
+		node.Bucket = start
+
+		query := `INSERT INTO node_rollups ("period", "bucket", "node_id", "samples", "avg_peers", "avg_uptime", "active_ratio")
+			VALUES (:period, :bucket, :node_id, :samples, :avg_peers, :avg_uptime, :active_ratio)
+			ON CONFLICT (period, bucket, node_id) DO UPDATE SET samples = excluded.samples, avg_peers = excluded.avg_peers,
+			avg_uptime = excluded.avg_uptime, active_ratio = excluded.active_ratio`
+
+		if _, err := tx.NamedExec(query, node); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 	return nil
 }
 
+func (s *State) LastRollup(period RollupPeriod) (time.Time, error) {
+	rollup := ChainRollup{}
+	if err := s.db.Get(&rollup, "SELECT * FROM chain_rollups WHERE period = $1 ORDER BY bucket DESC LIMIT 1", period); err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return rollup.Bucket.UTC(), nil
+}
+
+func (s *State) ListChainRollups(period RollupPeriod, from, before time.Time, limit int) ([]*ChainRollup, error) {
+	conds, args := rollupConds(period, from, before)
+	args = append(args, limit)
+	query := "SELECT * FROM chain_rollups WHERE " + strings.Join(conds, " AND ") + fmt.Sprintf(" ORDER BY bucket DESC LIMIT $%d", len(args))
+
+	rollups := []*ChainRollup{}
+	if err := s.db.Select(&rollups, query, args...); err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
+
+func (s *State) ListNodeRollups(nodeID string, period RollupPeriod, from, before time.Time, limit int) ([]*NodeRollup, error) {
+	conds, args := rollupConds(period, from, before)
+	args = append(args, nodeID)
+	conds = append(conds, fmt.Sprintf("node_id = $%d", len(args)))
+	args = append(args, limit)
+	query := "SELECT * FROM node_rollups WHERE " + strings.Join(conds, " AND ") + fmt.Sprintf(" ORDER BY bucket DESC LIMIT $%d", len(args))
+
+	rollups := []*NodeRollup{}
+	if err := s.db.Select(&rollups, query, args...); err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
+
+// rollupConds returns the conditions to filter the rollups by period and bucket
+func rollupConds(period RollupPeriod, from, before time.Time) ([]string, []interface{}) {
+	conds := []string{"period = $1"}
+	args := []interface{}{period}
+	if !from.IsZero() {
+		args = append(args, from.UTC())
+		conds = append(conds, fmt.Sprintf("bucket >= $%d", len(args)))
+	}
+	if !before.IsZero() {
+		args = append(args, before.UTC())
+		conds = append(conds, fmt.Sprintf("bucket < $%d", len(args)))
+	}
+	return conds, args
+}
+
 // Deletes data older than x seconds
 func (s *State) DeleteOlderData(seconds int) error {
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	query := fmt.Sprintf("DELETE FROM public.blocks WHERE public.blocks.created_at < now() - interval '%d seconds';", seconds)
+	query := "DELETE FROM blocks WHERE " + s.dialect.olderThan("blocks.created_at", seconds)
 	if _, err := tx.Exec(query); err != nil {
 		return err
 	}
 
-	query = fmt.Sprintf("DELETE FROM public.headevents WHERE public.headevents.created_at < now() - interval '%d seconds';", seconds)
+	query = "DELETE FROM headevents WHERE " + s.dialect.olderThan("headevents.created_at", seconds)
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	query = "DELETE FROM block_arrivals WHERE " + s.dialect.olderThan("block_arrivals.received_at", seconds)
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	query = "DELETE FROM node_latency WHERE " + s.dialect.olderThan("node_latency.reported_at", seconds)
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	query = "DELETE FROM node_pending WHERE " + s.dialect.olderThan("node_pending.reported_at", seconds)
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	query = "DELETE FROM nodestats_history WHERE " + s.dialect.olderThan("nodestats_history.reported_at", seconds)
 	if _, err := tx.Exec(query); err != nil {
 		return err
 	}
@@ -243,6 +602,77 @@ func (s *State) DeleteOlderData(seconds int) error {
 	return nil
 }
 
+func (s *State) CountRows(table string, cutoff Cutoff) (int, error) {
+	cond, args, err := purgeCond(s.dialect, table, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := s.db.Get(&count, fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", table, cond), args...); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *State) DeleteRows(table string, cutoff Cutoff, limit int) (int, error) {
+	cond, args, err := purgeCond(s.dialect, table, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	args = append(args, limit)
+
+	if table == TableNodeInfo {
+		return s.deleteNodes(cond, args)
+	}
+
+	// each batch is deleted in its own statement to keep the transactions short
+	rowID := s.dialect.rowID
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s IN (SELECT %s FROM %s WHERE %s LIMIT $%d)", table, rowID, rowID, table, cond, len(args))
+
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(deleted), nil
+}
+
+// deleteNodes deletes the nodes that match the condition along with their latest stats
+func (s *State) deleteNodes(cond string, args []interface{}) (int, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	nodeIDs := []string{}
+	if err := tx.Select(&nodeIDs, fmt.Sprintf("SELECT node_id FROM nodeinfo WHERE %s LIMIT $%d", cond, len(args)), args...); err != nil {
+		return 0, err
+	}
+	if len(nodeIDs) == 0 {
+		return 0, nil
+	}
+
+	for _, table := range []string{TableNodeStats, TableNodeInfo} {
+		query, args, err := sqlx.In(fmt.Sprintf("DELETE FROM %s WHERE node_id IN (?)", table), nodeIDs)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(tx.Rebind(query), args...); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(nodeIDs), nil
+}
+
 func (s *State) GetHeadEvent(eventID string) (*HeadEvent, error) {
 	evnt := HeadEvent{
 		Added:   []BlockStub{},
@@ -317,8 +747,190 @@ func (s *State) WriteHeadEvent(nodeID string, evnt *HeadEvent) (string, error) {
 	return ulid, nil
 }
 
+func (s *State) ListHeadEvents(nodeID string, before string, limit int) ([]*NodeHeadEvent, error) {
+	conds := []string{}
+	args := []interface{}{}
+	if nodeID != "" {
+		args = append(args, nodeID)
+		conds = append(conds, fmt.Sprintf("node_id = $%d", len(args)))
+	}
+	if before != "" {
+		// ulids are sorted by creation time
+		args = append(args, before)
+		conds = append(conds, fmt.Sprintf("event_id < $%d", len(args)))
+	}
+	query := "SELECT event_id, node_id, typ, created_at FROM headevents"
+	if len(conds) != 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY event_id DESC LIMIT $%d", len(args))
+
+	rows := []struct {
+		ID        string    `db:"event_id"`
+		NodeID    string    `db:"node_id"`
+		Type      string    `db:"typ"`
+		CreatedAt time.Time `db:"created_at"`
+	}{}
+	if err := s.db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return []*NodeHeadEvent{}, nil
+	}
+
+	events := []*NodeHeadEvent{}
+	byID := map[string]*HeadEvent{}
+	ids := []string{}
+	for _, row := range rows {
+		evnt := &HeadEvent{
+			Added:   []BlockStub{},
+			Removed: []BlockStub{},
+			Type:    row.Type,
+		}
+		events = append(events, &NodeHeadEvent{
+			HeadEvent: evnt,
+			ID:        row.ID,
+			NodeID:    row.NodeID,
+			CreatedAt: row.CreatedAt,
+		})
+		byID[row.ID] = evnt
+		ids = append(ids, row.ID)
+	}
+
+	query, args, err := sqlx.In("SELECT event_id, block_number, block_hash, parent_hash, typ FROM headentry WHERE event_id IN (?)", ids)
+	if err != nil {
+		return nil, err
+	}
+	stubs := []struct {
+		BlockStub
+		EventID string `db:"event_id"`
+		Type    string `db:"typ"`
+	}{}
+	if err := s.db.Select(&stubs, s.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, stub := range stubs {
+		evnt := byID[stub.EventID]
+		if stub.Type == "add" {
+			evnt.Added = append(evnt.Added, stub.BlockStub)
+		} else {
+			evnt.Removed = append(evnt.Removed, stub.BlockStub)
+		}
+	}
+	return events, nil
+}
+
+func (s *State) WriteReorg(reorg *Reorg) error {
+	query := `INSERT INTO reorgs
+		("event_id", "node_id", "common_ancestor_hash", "common_ancestor_number", "depth", "dropped_hashes", "new_hashes", "detected_at")
+		VALUES (:event_id, :node_id, :common_ancestor_hash, :common_ancestor_number, :depth, :dropped_hashes, :new_hashes, :detected_at)`
+
+	if _, err := s.db.NamedExec(query, reorg); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) ListReorgs(nodeID string, before string, limit int) ([]*Reorg, error) {
+	conds := []string{}
+	args := []interface{}{}
+	if nodeID != "" {
+		args = append(args, nodeID)
+		conds = append(conds, fmt.Sprintf("node_id = $%d", len(args)))
+	}
+	if before != "" {
+		args = append(args, before)
+		conds = append(conds, fmt.Sprintf("event_id < $%d", len(args)))
+	}
+	query := "SELECT * FROM reorgs"
+	if len(conds) != 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY event_id DESC LIMIT $%d", len(args))
+
+	reorgs := []*Reorg{}
+	if err := s.db.Select(&reorgs, query, args...); err != nil {
+		return nil, err
+	}
+	return reorgs, nil
+}
+
+func (s *State) WriteChainSplit(split *ChainSplit) error {
+	query := `INSERT INTO chain_splits
+		("split_id", "height", "fork_number", "fork_hash", "branches", "detected_at", "resolved_at")
+		VALUES (:split_id, :height, :fork_number, :fork_hash, :branches, :detected_at, :resolved_at)
+		ON CONFLICT (split_id) DO UPDATE SET branches = excluded.branches, resolved_at = excluded.resolved_at`
+
+	if _, err := s.db.NamedExec(query, split); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) ListChainSplits(before string, limit int) ([]*ChainSplit, error) {
+	splits := []*ChainSplit{}
+
+	var err error
+	if before != "" {
+		err = s.db.Select(&splits, "SELECT * FROM chain_splits WHERE split_id < $1 ORDER BY split_id DESC LIMIT $2", before, limit)
+	} else {
+		err = s.db.Select(&splits, "SELECT * FROM chain_splits ORDER BY split_id DESC LIMIT $1", limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splits, nil
+}
+
+func (s *State) WriteSessionEvent(evnt *SessionEvent) error {
+	query := `INSERT INTO session_events
+		("event_id", "node_id", "type", "remote_addr", "reason", "messages", "created_at")
+		VALUES (:event_id, :node_id, :type, :remote_addr, :reason, :messages, :created_at)`
+
+	if _, err := s.db.NamedExec(query, evnt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *State) ListSessionEvents(nodeID string, before string, limit int) ([]*SessionEvent, error) {
+	conds := []string{}
+	args := []interface{}{}
+	if nodeID != "" {
+		args = append(args, nodeID)
+		conds = append(conds, fmt.Sprintf("node_id = $%d", len(args)))
+	}
+	if before != "" {
+		args = append(args, before)
+		conds = append(conds, fmt.Sprintf("event_id < $%d", len(args)))
+	}
+	query := "SELECT * FROM session_events"
+	if len(conds) != 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY event_id DESC LIMIT $%d", len(args))
+
+	events := []*SessionEvent{}
+	if err := s.db.Select(&events, query, args...); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+var (
+	// ulidEntropy makes the ids created within the same millisecond increase
+	ulidEntropy = ulid.Monotonic(rand.Reader, 0)
+	ulidLock    sync.Mutex
+)
+
 func newUlid() (string, error) {
-	id, err := ulid.New(ulid.Now(), rand.Reader)
+	ulidLock.Lock()
+	defer ulidLock.Unlock()
+
+	id, err := ulid.New(ulid.Now(), ulidEntropy)
 	if err != nil {
 		return "", err
 	}
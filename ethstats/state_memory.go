@@ -0,0 +1,1087 @@
+package ethstats
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memBlock struct {
+	block     Block
+	createdAt time.Time
+}
+
+type memArrivalKey struct {
+	nodeID string
+	hash   string
+}
+
+type memRollupKey struct {
+	period RollupPeriod
+	bucket int64
+	nodeID string
+}
+
+type memHeadEvent struct {
+	nodeID    string
+	event     HeadEvent
+	createdAt time.Time
+}
+
+// MemoryState is an in-memory Store. The data is lost once the process stops,
+// it is meant to be used for testing and ephemeral deployments.
+type MemoryState struct {
+	lock sync.Mutex
+
+	blocks     map[string]*memBlock
+	nodeInfo   map[string]*NodeInfo
+	nodeStats  map[string]*NodeStats
+	headEvents map[string]*memHeadEvent
+	reorgs     map[string]*Reorg
+	splits     map[string]*ChainSplit
+	arrivals   map[memArrivalKey]*BlockArrival
+	latency    []*NodeLatency
+	pending    []*NodePending
+	sessions   map[string]*SessionEvent
+
+	nodeStatsHistory []*NodeStatsSnapshot
+	nodeStatsUpdated map[string]time.Time
+
+	chainRollups map[memRollupKey]*ChainRollup
+	nodeRollups  map[memRollupKey]*NodeRollup
+}
+
+func NewMemoryState() *MemoryState {
+	return &MemoryState{
+		blocks:     map[string]*memBlock{},
+		nodeInfo:   map[string]*NodeInfo{},
+		nodeStats:  map[string]*NodeStats{},
+		headEvents: map[string]*memHeadEvent{},
+		reorgs:     map[string]*Reorg{},
+		splits:     map[string]*ChainSplit{},
+		arrivals:   map[memArrivalKey]*BlockArrival{},
+		sessions:   map[string]*SessionEvent{},
+
+		nodeStatsUpdated: map[string]time.Time{},
+
+		chainRollups: map[memRollupKey]*ChainRollup{},
+		nodeRollups:  map[memRollupKey]*NodeRollup{},
+	}
+}
+
+func (m *MemoryState) Close() {
+}
+
+func (m *MemoryState) GetBlock(hash string) (*Block, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	b, ok := m.blocks[hash]
+	if !ok {
+		return nil, nil
+	}
+
+	// only the fields stored by the sql backends are returned
+	block := Block{
+		Number:     b.block.Number,
+		Hash:       b.block.Hash,
+		ParentHash: b.block.ParentHash,
+		Timestamp:  b.block.Timestamp,
+		Miner:      b.block.Miner,
+		GasUsed:    b.block.GasUsed,
+		GasLimit:   b.block.GasLimit,
+		Diff:       b.block.Diff,
+		TotalDiff:  b.block.TotalDiff,
+		TxHash:     b.block.TxHash,
+		Root:       b.block.Root,
+		Status:     b.block.Status,
+		Finalized:  b.block.Finalized,
+		Txs:        append([]TxStats{}, b.block.Txs...),
+	}
+	return &block, nil
+}
+
+func (m *MemoryState) WriteBlock(config *Config, b *Block) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// do not include a block twice
+	if _, ok := m.blocks[b.Hash]; ok {
+		return nil
+	}
+
+	// add default values for 'difficulty' and 'total_difficulty' which are pointers
+	if b.Diff == nil {
+		b.Diff = argBigPtr(big.NewInt(0))
+	}
+	if b.TotalDiff == nil {
+		b.TotalDiff = argBigPtr(big.NewInt(0))
+	}
+
+	block := *b
+	block.Status = BlockStatusUnknown
+	block.Finalized = false
+	block.Txs = nil
+	if config.ShouldSaveBlockTxs {
+		block.Txs = append([]TxStats{}, b.Txs...)
+	}
+
+	m.blocks[b.Hash] = &memBlock{
+		block:     block,
+		createdAt: time.Now(),
+	}
+	return nil
+}
+
+func (m *MemoryState) ListBlocks(from, to, limit int) ([]*Block, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	blocks := []*Block{}
+	for _, b := range m.blocks {
+		if b.block.Number < from || b.block.Number > to {
+			continue
+		}
+		block := b.block
+		block.Txs = nil
+		block.Uncles = nil
+		blocks = append(blocks, &block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].Number != blocks[j].Number {
+			return blocks[i].Number > blocks[j].Number
+		}
+		return blocks[i].Hash < blocks[j].Hash
+	})
+	if len(blocks) > limit {
+		blocks = blocks[:limit]
+	}
+	return blocks, nil
+}
+
+func (m *MemoryState) ListBlockNumbers(from, to int) ([]int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	found := map[int]struct{}{}
+	for _, b := range m.blocks {
+		if b.block.Number >= from && b.block.Number <= to {
+			found[b.block.Number] = struct{}{}
+		}
+	}
+	numbers := []int{}
+	for num := range found {
+		numbers = append(numbers, num)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (m *MemoryState) SetBlockStatus(hash string, status string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if b, ok := m.blocks[hash]; ok && !b.block.Finalized {
+		b.block.Status = status
+	}
+	return nil
+}
+
+func (m *MemoryState) GetCanonicalBlock(number int) (*Block, error) {
+	hash := ""
+	m.lock.Lock()
+	for _, b := range m.blocks {
+		if b.block.Number == number && b.block.Status == BlockStatusCanonical {
+			hash = b.block.Hash
+			break
+		}
+	}
+	m.lock.Unlock()
+
+	if hash == "" {
+		return nil, nil
+	}
+	return m.GetBlock(hash)
+}
+
+func (m *MemoryState) GetCanonicalHead() (*Block, error) {
+	var head *memBlock
+	m.lock.Lock()
+	for _, b := range m.blocks {
+		if b.block.Status != BlockStatusCanonical {
+			continue
+		}
+		if head == nil || b.block.Number > head.block.Number {
+			head = b
+		}
+	}
+	m.lock.Unlock()
+
+	if head == nil {
+		return nil, nil
+	}
+	return m.GetBlock(head.block.Hash)
+}
+
+func (m *MemoryState) FinalizeBlocks(number int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, b := range m.blocks {
+		if b.block.Number <= number && b.block.Status == BlockStatusCanonical {
+			b.block.Finalized = true
+		}
+	}
+	return nil
+}
+
+func (m *MemoryState) WriteBlockArrival(arrival *BlockArrival) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := memArrivalKey{nodeID: arrival.NodeID, hash: arrival.BlockHash}
+	if _, ok := m.arrivals[key]; !ok {
+		arrivalCopy := *arrival
+		m.arrivals[key] = &arrivalCopy
+	}
+	return nil
+}
+
+func (m *MemoryState) GetBlockArrivals(hash string) ([]*BlockArrival, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	arrivals := []*BlockArrival{}
+	for key, arrival := range m.arrivals {
+		if key.hash == hash {
+			arrivalCopy := *arrival
+			arrivals = append(arrivals, &arrivalCopy)
+		}
+	}
+	sortArrivals(arrivals)
+	return arrivals, nil
+}
+
+func (m *MemoryState) ListBlockArrivals(from, to int) ([]*BlockArrival, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	arrivals := []*BlockArrival{}
+	for _, arrival := range m.arrivals {
+		if arrival.BlockNumber >= from && arrival.BlockNumber <= to {
+			arrivalCopy := *arrival
+			arrivals = append(arrivals, &arrivalCopy)
+		}
+	}
+	sortArrivals(arrivals)
+	return arrivals, nil
+}
+
+// sortArrivals sorts the arrivals by number, hash, reception time and node
+func sortArrivals(arrivals []*BlockArrival) {
+	sort.Slice(arrivals, func(i, j int) bool {
+		a, b := arrivals[i], arrivals[j]
+		if a.BlockNumber != b.BlockNumber {
+			return a.BlockNumber < b.BlockNumber
+		}
+		if a.BlockHash != b.BlockHash {
+			return a.BlockHash < b.BlockHash
+		}
+		if !a.ReceivedAt.Equal(b.ReceivedAt) {
+			return a.ReceivedAt.Before(b.ReceivedAt)
+		}
+		return a.NodeID < b.NodeID
+	})
+}
+
+func (m *MemoryState) GetNodeInfo(nodeID string) (*NodeInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	info, ok := m.nodeInfo[nodeID]
+	if !ok {
+		return nil, nil
+	}
+	infoCopy := *info
+	return &infoCopy, nil
+}
+
+func (m *MemoryState) WriteNodeInfo(nodeInfo *NodeInfo) error {
+	nodeID := nodeInfo.Name
+	if nodeID == "" {
+		return fmt.Errorf("node id is empty")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	info := *nodeInfo
+	if prev, ok := m.nodeInfo[nodeID]; ok {
+		info.CreatedAt = prev.CreatedAt
+	} else {
+		info.CreatedAt = time.Now()
+	}
+	m.nodeInfo[nodeID] = &info
+
+	// write the initial node stats with empty values
+	if _, ok := m.nodeStats[nodeID]; !ok {
+		m.nodeStats[nodeID] = &NodeStats{}
+		m.nodeStatsUpdated[nodeID] = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryState) ListNodes() ([]*NodeInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	nodes := []*NodeInfo{}
+	for _, info := range m.nodeInfo {
+		infoCopy := *info
+		nodes = append(nodes, &infoCopy)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes, nil
+}
+
+func (m *MemoryState) GetNodeStats(nodeID string) (*NodeStats, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stats, ok := m.nodeStats[nodeID]
+	if !ok {
+		return nil, nil
+	}
+	statsCopy := *stats
+	return &statsCopy, nil
+}
+
+func (m *MemoryState) WriteNodeStats(nodeID string, stats *NodeStats) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// like the sql backends, only the stats of known nodes are updated
+	if _, ok := m.nodeStats[nodeID]; ok {
+		statsCopy := *stats
+		m.nodeStats[nodeID] = &statsCopy
+		m.nodeStatsUpdated[nodeID] = time.Now()
+
+		m.nodeStatsHistory = append(m.nodeStatsHistory, &NodeStatsSnapshot{
+			NodeStats:  *stats,
+			NodeID:     nodeID,
+			ReportedAt: time.Now().UTC(),
+		})
+	}
+	return nil
+}
+
+func (m *MemoryState) SetNodeActive(nodeID string, active bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if stats, ok := m.nodeStats[nodeID]; ok {
+		stats.Active = active
+	}
+	return nil
+}
+
+func (m *MemoryState) GetNodeStatsAt(nodeID string, at time.Time) (*NodeStatsSnapshot, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var res *NodeStatsSnapshot
+	for _, snapshot := range m.nodeStatsHistory {
+		if snapshot.NodeID != nodeID || snapshot.ReportedAt.After(at) {
+			continue
+		}
+		if res == nil || !snapshot.ReportedAt.Before(res.ReportedAt) {
+			res = snapshot
+		}
+	}
+	if res == nil {
+		return nil, nil
+	}
+	snapshotCopy := *res
+	return &snapshotCopy, nil
+}
+
+func (m *MemoryState) ListNodeStatsHistory(nodeID string, from, to time.Time, limit int) ([]*NodeStatsSnapshot, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	history := []*NodeStatsSnapshot{}
+	for _, snapshot := range m.nodeStatsHistory {
+		if snapshot.NodeID != nodeID {
+			continue
+		}
+		if !from.IsZero() && snapshot.ReportedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && snapshot.ReportedAt.After(to) {
+			continue
+		}
+		snapshotCopy := *snapshot
+		history = append(history, &snapshotCopy)
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].ReportedAt.Before(history[j].ReportedAt)
+	})
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
+
+func (m *MemoryState) GetHeadEvent(eventID string) (*HeadEvent, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	evnt, ok := m.headEvents[eventID]
+	if !ok {
+		return nil, nil
+	}
+	res := &HeadEvent{
+		Type:    evnt.event.Type,
+		Added:   append([]BlockStub{}, evnt.event.Added...),
+		Removed: append([]BlockStub{}, evnt.event.Removed...),
+	}
+	return res, nil
+}
+
+func (m *MemoryState) WriteHeadEvent(nodeID string, evnt *HeadEvent) (string, error) {
+	// we use an ulid to identify each head event
+	ulid, err := newUlid()
+	if err != nil {
+		return "", err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.headEvents[ulid] = &memHeadEvent{
+		nodeID: nodeID,
+		event: HeadEvent{
+			Type:    evnt.Type,
+			Added:   append([]BlockStub{}, evnt.Added...),
+			Removed: append([]BlockStub{}, evnt.Removed...),
+		},
+		createdAt: time.Now(),
+	}
+	return ulid, nil
+}
+
+func (m *MemoryState) ListHeadEvents(nodeID string, before string, limit int) ([]*NodeHeadEvent, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	events := []*NodeHeadEvent{}
+	for id, evnt := range m.headEvents {
+		if nodeID != "" && evnt.nodeID != nodeID {
+			continue
+		}
+		if before != "" && id >= before {
+			continue
+		}
+		events = append(events, &NodeHeadEvent{
+			HeadEvent: &HeadEvent{
+				Type:    evnt.event.Type,
+				Added:   append([]BlockStub{}, evnt.event.Added...),
+				Removed: append([]BlockStub{}, evnt.event.Removed...),
+			},
+			ID:        id,
+			NodeID:    evnt.nodeID,
+			CreatedAt: evnt.createdAt,
+		})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (m *MemoryState) WriteReorg(reorg *Reorg) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.reorgs[reorg.EventID]; ok {
+		return fmt.Errorf("reorg for event %s already exists", reorg.EventID)
+	}
+	reorgCopy := *reorg
+	m.reorgs[reorg.EventID] = &reorgCopy
+	return nil
+}
+
+func (m *MemoryState) ListReorgs(nodeID string, before string, limit int) ([]*Reorg, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	reorgs := []*Reorg{}
+	for id, reorg := range m.reorgs {
+		if nodeID != "" && reorg.NodeID != nodeID {
+			continue
+		}
+		if before != "" && id >= before {
+			continue
+		}
+		reorgCopy := *reorg
+		reorgs = append(reorgs, &reorgCopy)
+	}
+	sort.Slice(reorgs, func(i, j int) bool {
+		return reorgs[i].EventID > reorgs[j].EventID
+	})
+	if len(reorgs) > limit {
+		reorgs = reorgs[:limit]
+	}
+	return reorgs, nil
+}
+
+func (m *MemoryState) WriteSessionEvent(evnt *SessionEvent) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.sessions[evnt.ID]; ok {
+		return fmt.Errorf("session event %s already exists", evnt.ID)
+	}
+	evntCopy := *evnt
+	m.sessions[evnt.ID] = &evntCopy
+	return nil
+}
+
+func (m *MemoryState) ListSessionEvents(nodeID string, before string, limit int) ([]*SessionEvent, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	events := []*SessionEvent{}
+	for id, evnt := range m.sessions {
+		if nodeID != "" && evnt.NodeID != nodeID {
+			continue
+		}
+		if before != "" && id >= before {
+			continue
+		}
+		evntCopy := *evnt
+		events = append(events, &evntCopy)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (m *MemoryState) WriteChainSplit(split *ChainSplit) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if prev, ok := m.splits[split.ID]; ok {
+		// only the branches and the resolution are updated
+		updated := prev.Copy()
+		updated.Branches = split.Copy().Branches
+		updated.ResolvedAt = split.Copy().ResolvedAt
+		m.splits[split.ID] = updated
+		return nil
+	}
+	m.splits[split.ID] = split.Copy()
+	return nil
+}
+
+func (m *MemoryState) ListChainSplits(before string, limit int) ([]*ChainSplit, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	splits := []*ChainSplit{}
+	for id, split := range m.splits {
+		if before != "" && id >= before {
+			continue
+		}
+		splits = append(splits, split.Copy())
+	}
+	sort.Slice(splits, func(i, j int) bool {
+		return splits[i].ID > splits[j].ID
+	})
+	if len(splits) > limit {
+		splits = splits[:limit]
+	}
+	return splits, nil
+}
+
+func (m *MemoryState) WriteNodeLatency(latency *NodeLatency) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	latencyCopy := *latency
+	m.latency = append(m.latency, &latencyCopy)
+	return nil
+}
+
+func (m *MemoryState) ListNodeLatency(nodeID string, since time.Time, limit int) ([]*NodeLatency, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	latencies := []*NodeLatency{}
+	for _, latency := range m.latency {
+		if latency.NodeID != nodeID {
+			continue
+		}
+		if !since.IsZero() && latency.ReportedAt.Before(since) {
+			continue
+		}
+		latencyCopy := *latency
+		latencies = append(latencies, &latencyCopy)
+	}
+	sort.SliceStable(latencies, func(i, j int) bool {
+		return latencies[i].ReportedAt.After(latencies[j].ReportedAt)
+	})
+	if len(latencies) > limit {
+		latencies = latencies[:limit]
+	}
+	return latencies, nil
+}
+
+func (m *MemoryState) WriteNodePending(pending *NodePending) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	pendingCopy := *pending
+	m.pending = append(m.pending, &pendingCopy)
+	return nil
+}
+
+func (m *MemoryState) GetNodePending(nodeID string) (*NodePending, error) {
+	pending, err := m.ListNodePending(nodeID, time.Time{}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	return pending[0], nil
+}
+
+func (m *MemoryState) ListNodePending(nodeID string, since time.Time, limit int) ([]*NodePending, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res := []*NodePending{}
+	for _, pending := range m.pending {
+		if pending.NodeID != nodeID {
+			continue
+		}
+		if !since.IsZero() && pending.ReportedAt.Before(since) {
+			continue
+		}
+		pendingCopy := *pending
+		res = append(res, &pendingCopy)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].ReportedAt.After(res[j].ReportedAt)
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (m *MemoryState) CountRows(table string, cutoff Cutoff) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.purgeRows(table, cutoff, math.MaxInt32, false)
+}
+
+func (m *MemoryState) DeleteRows(table string, cutoff Cutoff, limit int) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.purgeRows(table, cutoff, limit, true)
+}
+
+// purgeRows counts up to limit rows of the table selected by the cutoff
+// and deletes them if remove is set, like the purge conditions of the sql backends
+func (m *MemoryState) purgeRows(table string, cutoff Cutoff, limit int, remove bool) (int, error) {
+	threshold := time.Now().Add(-cutoff.Age)
+	olderThan := func(t time.Time) bool {
+		return cutoff.Age == 0 || t.Before(threshold)
+	}
+	lowerThan := func(number int) bool {
+		return cutoff.BlockNumber == 0 || number < cutoff.BlockNumber
+	}
+
+	if err := cutoff.Validate(table); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	switch table {
+	case TableBlocks:
+		for hash, b := range m.blocks {
+			if count < limit && olderThan(b.createdAt) && lowerThan(b.block.Number) {
+				if remove {
+					delete(m.blocks, hash)
+				}
+				count++
+			}
+		}
+
+	case TableBlockTxs:
+		for _, b := range m.blocks {
+			if !olderThan(b.createdAt) || !lowerThan(b.block.Number) {
+				continue
+			}
+			num := len(b.block.Txs)
+			if num > limit-count {
+				num = limit - count
+			}
+			if remove {
+				b.block.Txs = b.block.Txs[num:]
+			}
+			count += num
+		}
+
+	case TableBlockArrivals:
+		for key, arrival := range m.arrivals {
+			if count < limit && olderThan(arrival.ReceivedAt) && lowerThan(arrival.BlockNumber) {
+				if remove {
+					delete(m.arrivals, key)
+				}
+				count++
+			}
+		}
+
+	case TableHeadEvents:
+		for id, evnt := range m.headEvents {
+			number := -1
+			for _, stub := range append(append([]BlockStub{}, evnt.event.Added...), evnt.event.Removed...) {
+				if stub.Number > number {
+					number = stub.Number
+				}
+			}
+			// like in the sql backends, the events without blocks have no number
+			if cutoff.BlockNumber != 0 && number == -1 {
+				continue
+			}
+			if count < limit && olderThan(evnt.createdAt) && lowerThan(number) {
+				if remove {
+					delete(m.headEvents, id)
+				}
+				count++
+			}
+		}
+
+	case TableNodeLatency:
+		latency := []*NodeLatency{}
+		for _, l := range m.latency {
+			if count < limit && olderThan(l.ReportedAt) {
+				count++
+				continue
+			}
+			latency = append(latency, l)
+		}
+		if remove {
+			m.latency = latency
+		}
+
+	case TableNodePending:
+		pending := []*NodePending{}
+		for _, p := range m.pending {
+			if count < limit && olderThan(p.ReportedAt) {
+				count++
+				continue
+			}
+			pending = append(pending, p)
+		}
+		if remove {
+			m.pending = pending
+		}
+
+	case TableNodeStatsHistory:
+		statsHistory := []*NodeStatsSnapshot{}
+		for _, snapshot := range m.nodeStatsHistory {
+			if count < limit && olderThan(snapshot.ReportedAt) {
+				count++
+				continue
+			}
+			statsHistory = append(statsHistory, snapshot)
+		}
+		if remove {
+			m.nodeStatsHistory = statsHistory
+		}
+
+	case TableSessionEvents:
+		for id, evnt := range m.sessions {
+			if count < limit && olderThan(evnt.CreatedAt) {
+				if remove {
+					delete(m.sessions, id)
+				}
+				count++
+			}
+		}
+
+	case TableNodeStats:
+		for nodeID := range m.nodeStats {
+			if _, ok := m.nodeInfo[nodeID]; !ok && count < limit {
+				if remove {
+					delete(m.nodeStats, nodeID)
+					delete(m.nodeStatsUpdated, nodeID)
+				}
+				count++
+			}
+		}
+
+	case TableNodeInfo:
+		referenced := m.referencedNodes()
+		for nodeID := range m.nodeInfo {
+			if referenced[nodeID] || count >= limit {
+				continue
+			}
+			if updatedAt, ok := m.nodeStatsUpdated[nodeID]; ok && !olderThan(updatedAt) {
+				continue
+			}
+			if remove {
+				delete(m.nodeInfo, nodeID)
+				delete(m.nodeStats, nodeID)
+				delete(m.nodeStatsUpdated, nodeID)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// referencedNodes returns the nodes referenced by the events, reorgs and time series
+func (m *MemoryState) referencedNodes() map[string]bool {
+	nodes := map[string]bool{}
+	for _, evnt := range m.headEvents {
+		nodes[evnt.nodeID] = true
+	}
+	for _, reorg := range m.reorgs {
+		nodes[reorg.NodeID] = true
+	}
+	for _, arrival := range m.arrivals {
+		nodes[arrival.NodeID] = true
+	}
+	for _, l := range m.latency {
+		nodes[l.NodeID] = true
+	}
+	for _, p := range m.pending {
+		nodes[p.NodeID] = true
+	}
+	for _, snapshot := range m.nodeStatsHistory {
+		nodes[snapshot.NodeID] = true
+	}
+	return nodes
+}
+
+func (m *MemoryState) WriteRollup(period RollupPeriod, bucket time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	start := period.truncate(bucket)
+	end := start.Add(period.duration())
+
+	chain := &ChainRollup{Period: period, Bucket: start}
+
+	var gasRatio float64
+	var gasCount int
+	var minTimestamp, maxTimestamp int64
+	for _, b := range m.blocks {
+		timestamp := int64(b.block.Timestamp)
+		if timestamp < start.Unix() || timestamp >= end.Unix() {
+			continue
+		}
+		if b.block.Status == BlockStatusOrphaned || b.block.Status == BlockStatusUncle {
+			continue
+		}
+		if chain.BlockCount == 0 || timestamp < minTimestamp {
+			minTimestamp = timestamp
+		}
+		if chain.BlockCount == 0 || timestamp > maxTimestamp {
+			maxTimestamp = timestamp
+		}
+		chain.BlockCount++
+		if b.block.GasLimit > 0 {
+			gasRatio += float64(b.block.GasUsed) / float64(b.block.GasLimit)
+			gasCount++
+		}
+	}
+	if gasCount != 0 {
+		chain.AvgGasRatio = gasRatio / float64(gasCount)
+	}
+	chain.AvgBlockTime = avgBlockTime(chain.BlockCount, minTimestamp, maxTimestamp)
+
+	for _, reorg := range m.reorgs {
+		if !reorg.DetectedAt.Before(start) && reorg.DetectedAt.Before(end) {
+			chain.ReorgCount++
+		}
+	}
+	m.chainRollups[memRollupKey{period: period, bucket: start.Unix()}] = chain
+
+	type nodeSums struct {
+		samples, peers, uptime, active int
+	}
+	sums := map[string]*nodeSums{}
+	for _, snapshot := range m.nodeStatsHistory {
+		if snapshot.ReportedAt.Before(start) || !snapshot.ReportedAt.Before(end) {
+			continue
+		}
+		sum, ok := sums[snapshot.NodeID]
+		if !ok {
+			sum = &nodeSums{}
+			sums[snapshot.NodeID] = sum
+		}
+		sum.samples++
+		sum.peers += snapshot.Peers
+		sum.uptime += snapshot.Uptime
+		if snapshot.Active {
+			sum.active++
+		}
+	}
+	for nodeID, sum := range sums {
+		m.nodeRollups[memRollupKey{period: period, bucket: start.Unix(), nodeID: nodeID}] = &NodeRollup{
+			Period:      period,
+			Bucket:      start,
+			NodeID:      nodeID,
+			Samples:     sum.samples,
+			AvgPeers:    float64(sum.peers) / float64(sum.samples),
+			AvgUptime:   float64(sum.uptime) / float64(sum.samples),
+			ActiveRatio: float64(sum.active) / float64(sum.samples),
+		}
+	}
+	return nil
+}
+
+func (m *MemoryState) LastRollup(period RollupPeriod) (time.Time, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var last time.Time
+	for _, rollup := range m.chainRollups {
+		if rollup.Period == period && rollup.Bucket.After(last) {
+			last = rollup.Bucket
+		}
+	}
+	return last, nil
+}
+
+func (m *MemoryState) ListChainRollups(period RollupPeriod, from, before time.Time, limit int) ([]*ChainRollup, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	rollups := []*ChainRollup{}
+	for _, rollup := range m.chainRollups {
+		if rollup.Period != period || !inRollupRange(rollup.Bucket, from, before) {
+			continue
+		}
+		rollupCopy := *rollup
+		rollups = append(rollups, &rollupCopy)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Bucket.After(rollups[j].Bucket)
+	})
+	if len(rollups) > limit {
+		rollups = rollups[:limit]
+	}
+	return rollups, nil
+}
+
+func (m *MemoryState) ListNodeRollups(nodeID string, period RollupPeriod, from, before time.Time, limit int) ([]*NodeRollup, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	rollups := []*NodeRollup{}
+	for _, rollup := range m.nodeRollups {
+		if rollup.NodeID != nodeID || rollup.Period != period || !inRollupRange(rollup.Bucket, from, before) {
+			continue
+		}
+		rollupCopy := *rollup
+		rollups = append(rollups, &rollupCopy)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Bucket.After(rollups[j].Bucket)
+	})
+	if len(rollups) > limit {
+		rollups = rollups[:limit]
+	}
+	return rollups, nil
+}
+
+// inRange returns whether t is between from and to (if not zero, inclusive)
+func inRange(t, from, to time.Time) bool {
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && t.After(to) {
+		return false
+	}
+	return true
+}
+
+// inRollupRange returns whether the bucket is since from (if not zero,
+// inclusive) and older than before (if not zero)
+func inRollupRange(bucket, from, before time.Time) bool {
+	if !from.IsZero() && bucket.Before(from) {
+		return false
+	}
+	if !before.IsZero() && !bucket.Before(before) {
+		return false
+	}
+	return true
+}
+
+// Deletes data older than x seconds
+func (m *MemoryState) DeleteOlderData(seconds int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	threshold := time.Now().Add(-time.Duration(seconds) * time.Second)
+
+	for hash, b := range m.blocks {
+		if b.createdAt.Before(threshold) {
+			delete(m.blocks, hash)
+		}
+	}
+	for id, evnt := range m.headEvents {
+		if evnt.createdAt.Before(threshold) {
+			delete(m.headEvents, id)
+		}
+	}
+	for key, arrival := range m.arrivals {
+		if arrival.ReceivedAt.Before(threshold) {
+			delete(m.arrivals, key)
+		}
+	}
+	latency := []*NodeLatency{}
+	for _, l := range m.latency {
+		if !l.ReportedAt.Before(threshold) {
+			latency = append(latency, l)
+		}
+	}
+	m.latency = latency
+
+	pending := []*NodePending{}
+	for _, p := range m.pending {
+		if !p.ReportedAt.Before(threshold) {
+			pending = append(pending, p)
+		}
+	}
+	m.pending = pending
+
+	statsHistory := []*NodeStatsSnapshot{}
+	for _, snapshot := range m.nodeStatsHistory {
+		if !snapshot.ReportedAt.Before(threshold) {
+			statsHistory = append(statsHistory, snapshot)
+		}
+	}
+	m.nodeStatsHistory = statsHistory
+	return nil
+}
@@ -0,0 +1,61 @@
+package ethstats
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// NewSQLiteState creates a State backed by an SQLite database file.
+// The endpoint has the form 'sqlite:///path/to/file.db' with optional
+// connection parameters of the go-sqlite3 driver as query values.
+func NewSQLiteState(endpoint string) (*State, error) {
+	db, err := openSQLite(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return NewStateWithDB(db)
+}
+
+func openSQLite(endpoint string) (*sqlx.DB, error) {
+	dsn, err := sqliteDSN(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sqlx.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only allows one writer at a time, serialize all the
+	// queries over a single connection to avoid 'database is locked' errors.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func sqliteDSN(endpoint string) (string, error) {
+	path := endpoint
+	if indx := strings.Index(path, "://"); indx != -1 {
+		path = path[indx+3:]
+	}
+
+	var query string
+	if indx := strings.Index(path, "?"); indx != -1 {
+		path, query = path[:indx], path[indx+1:]
+	}
+	if path == "" {
+		return "", fmt.Errorf("sqlite endpoint '%s' does not have a path", endpoint)
+	}
+
+	// the foreign keys are required to cascade the deletes
+	if !strings.Contains(query, "_foreign_keys") && !strings.Contains(query, "_fk") {
+		if query != "" {
+			query += "&"
+		}
+		query += "_foreign_keys=on"
+	}
+	return "file:" + path + "?" + query, nil
+}
@@ -0,0 +1,59 @@
+package ethstats
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupSQLite(t *testing.T) (*State, func()) {
+	s, err := NewSQLiteState("sqlite://" + filepath.Join(t.TempDir(), "ethstats.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, s.Close
+}
+
+func TestSQLiteState(t *testing.T) {
+	runStoreTests(t, func(t *testing.T) (Store, func()) {
+		return setupSQLite(t)
+	})
+}
+
+func TestSQLiteState_Reopen(t *testing.T) {
+	endpoint := "sqlite://" + filepath.Join(t.TempDir(), "ethstats.db")
+
+	s, err := NewStore(endpoint)
+	assert.NoError(t, err)
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	s.Close()
+
+	// the migrations can run again on an existing database
+	s, err = NewStore(endpoint)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	info, err := s.GetNodeInfo("a")
+	assert.NoError(t, err)
+	assert.NotNil(t, info)
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		dsn      string
+	}{
+		{"sqlite:///var/lib/ethstats.db", "file:/var/lib/ethstats.db?_foreign_keys=on"},
+		{"sqlite://ethstats.db?_busy_timeout=5000", "file:ethstats.db?_busy_timeout=5000&_foreign_keys=on"},
+		{"sqlite://ethstats.db?_fk=off", "file:ethstats.db?_fk=off"},
+	}
+	for _, c := range cases {
+		dsn, err := sqliteDSN(c.endpoint)
+		assert.NoError(t, err)
+		assert.Equal(t, c.dsn, dsn)
+	}
+
+	_, err := sqliteDSN("sqlite://")
+	assert.Error(t, err)
+}
@@ -44,6 +44,24 @@ func setupPostgresql(t *testing.T) (*sqlx.DB, func()) {
 	return db, cleanup
 }
 
+func TestPostgresState(t *testing.T) {
+	db, closeFn := setupPostgresql(t)
+	defer closeFn()
+
+	runStoreTests(t, func(t *testing.T) (Store, func()) {
+		// every test starts from an empty schema on the same database
+		if _, err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewStateWithDB(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the database is closed with the container
+		return s, func() {}
+	})
+}
+
 var (
 	one    = big.NewInt(1)
 	config = &Config{ShouldSaveBlockTxs: true}
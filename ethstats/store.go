@@ -0,0 +1,191 @@
+package ethstats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Store is the storage backend used by the server to persist
+// the data reported by the nodes.
+type Store interface {
+	// GetBlock returns the block with the given hash or nil if it does not exist
+	GetBlock(hash string) (*Block, error)
+
+	// WriteBlock writes a block (only once per hash)
+	WriteBlock(config *Config, b *Block) error
+
+	// ListBlocks returns up to limit blocks (without transactions) with
+	// a number between from and to (inclusive) sorted by number descending
+	ListBlocks(from, to, limit int) ([]*Block, error)
+
+	// ListBlockNumbers returns the distinct numbers of the blocks
+	// between from and to (inclusive) sorted ascending
+	ListBlockNumbers(from, to int) ([]int, error)
+
+	// SetBlockStatus sets the status in the canonical chain of the block with
+	// the given hash. The status of the finalized blocks does not change.
+	SetBlockStatus(hash string, status string) error
+
+	// GetCanonicalBlock returns the canonical block at the given number or nil if it does not exist
+	GetCanonicalBlock(number int) (*Block, error)
+
+	// GetCanonicalHead returns the canonical block with the highest number or nil if it does not exist
+	GetCanonicalHead() (*Block, error)
+
+	// FinalizeBlocks marks as finalized the canonical blocks with a number lower or equal than the given one
+	FinalizeBlocks(number int) error
+
+	// WriteBlockArrival records when a node reported a block (only the first report of each node is kept)
+	WriteBlockArrival(arrival *BlockArrival) error
+
+	// GetBlockArrivals returns the arrivals of the block sorted by reception time
+	GetBlockArrivals(hash string) ([]*BlockArrival, error)
+
+	// ListBlockArrivals returns the arrivals of the blocks with a number between
+	// from and to (inclusive) sorted by number, hash and reception time
+	ListBlockArrivals(from, to int) ([]*BlockArrival, error)
+
+	// GetNodeInfo returns the info of the node or nil if it does not exist
+	GetNodeInfo(nodeID string) (*NodeInfo, error)
+
+	// WriteNodeInfo writes (or updates) the info of a node
+	WriteNodeInfo(nodeInfo *NodeInfo) error
+
+	// ListNodes returns the info of all the nodes sorted by id
+	ListNodes() ([]*NodeInfo, error)
+
+	// GetNodeStats returns the latest stats of the node or nil if it does not exist
+	GetNodeStats(nodeID string) (*NodeStats, error)
+
+	// WriteNodeStats updates the latest stats of the node and
+	// appends them to the stats history of the node
+	WriteNodeStats(nodeID string, stats *NodeStats) error
+
+	// SetNodeActive sets whether the node is active without
+	// changing its other stats nor appending them to the history
+	SetNodeActive(nodeID string, active bool) error
+
+	// GetNodeStatsAt returns the latest stats reported by the node at
+	// the given time or nil if the node did not report stats before
+	GetNodeStatsAt(nodeID string, at time.Time) (*NodeStatsSnapshot, error)
+
+	// ListNodeStatsHistory returns up to limit stats reported by the node between
+	// from and to (if not zero, inclusive) sorted from oldest to newest
+	ListNodeStatsHistory(nodeID string, from, to time.Time, limit int) ([]*NodeStatsSnapshot, error)
+
+	// WriteNodeLatency appends the latency reported by a node
+	WriteNodeLatency(latency *NodeLatency) error
+
+	// ListNodeLatency returns up to limit latencies of the node reported after
+	// since (if not zero) sorted from newest to oldest
+	ListNodeLatency(nodeID string, since time.Time, limit int) ([]*NodeLatency, error)
+
+	// WriteNodePending appends the number of pending transactions reported by a node
+	WriteNodePending(pending *NodePending) error
+
+	// GetNodePending returns the latest number of pending transactions
+	// reported by the node or nil if it does not exist
+	GetNodePending(nodeID string) (*NodePending, error)
+
+	// ListNodePending returns up to limit pending transaction counts of the
+	// node reported after since (if not zero) sorted from newest to oldest
+	ListNodePending(nodeID string, since time.Time, limit int) ([]*NodePending, error)
+
+	// GetHeadEvent returns the head event with the given id or nil if it does not exist
+	GetHeadEvent(eventID string) (*HeadEvent, error)
+
+	// WriteHeadEvent writes a head event for the node and returns its id
+	WriteHeadEvent(nodeID string, evnt *HeadEvent) (string, error)
+
+	// ListHeadEvents returns up to limit head events sorted from newest to oldest.
+	// The events are filtered by node (if not empty) and only the ones
+	// older than the 'before' event id (if not empty) are returned.
+	ListHeadEvents(nodeID string, before string, limit int) ([]*NodeHeadEvent, error)
+
+	// WriteReorg writes a reorg detected from a head event
+	WriteReorg(reorg *Reorg) error
+
+	// ListReorgs returns up to limit reorgs sorted from newest to oldest.
+	// The reorgs are filtered by node (if not empty) and only the ones
+	// older than the 'before' event id (if not empty) are returned.
+	ListReorgs(nodeID string, before string, limit int) ([]*Reorg, error)
+
+	// WriteChainSplit writes (or updates) a chain split between the nodes
+	WriteChainSplit(split *ChainSplit) error
+
+	// ListChainSplits returns up to limit chain splits sorted from newest to oldest.
+	// Only the splits older than the 'before' split id (if not empty) are returned.
+	ListChainSplits(before string, limit int) ([]*ChainSplit, error)
+
+	// WriteSessionEvent writes the connection or disconnection of a node
+	WriteSessionEvent(evnt *SessionEvent) error
+
+	// ListSessionEvents returns up to limit session events sorted from newest to oldest.
+	// The events are filtered by node (if not empty) and only the ones
+	// older than the 'before' event id (if not empty) are returned.
+	ListSessionEvents(nodeID string, before string, limit int) ([]*SessionEvent, error)
+
+	// WriteRollup computes (or recomputes) the chain and node rollups of
+	// the bucket of the period that starts at the given time
+	WriteRollup(period RollupPeriod, bucket time.Time) error
+
+	// LastRollup returns the start of the latest bucket computed
+	// for the period or the zero time if there is none
+	LastRollup(period RollupPeriod) (time.Time, error)
+
+	// ListChainRollups returns up to limit chain rollups of the period sorted from newest
+	// to oldest. Only the buckets since from (if not zero, inclusive) and older than
+	// before (if not zero) are returned.
+	ListChainRollups(period RollupPeriod, from, before time.Time, limit int) ([]*ChainRollup, error)
+
+	// ListNodeRollups returns up to limit rollups of the node for the period sorted from
+	// newest to oldest. Only the buckets since from (if not zero, inclusive) and older
+	// than before (if not zero) are returned.
+	ListNodeRollups(nodeID string, period RollupPeriod, from, before time.Time, limit int) ([]*NodeRollup, error)
+
+	// DeleteOlderData deletes the data older than the given seconds
+	DeleteOlderData(seconds int) error
+
+	// CountRows returns the number of rows of the table selected by the cutoff
+	CountRows(table string, cutoff Cutoff) (int, error)
+
+	// DeleteRows deletes up to limit rows of the table selected by the cutoff and
+	// returns the number of rows deleted. The transactions of the blocks are selected
+	// by their block and the nodes are deleted along with their latest stats.
+	DeleteRows(table string, cutoff Cutoff, limit int) (int, error)
+
+	// Close closes the store
+	Close()
+}
+
+var (
+	_ Store = (*State)(nil)
+	_ Store = (*MemoryState)(nil)
+)
+
+// NewStore creates the storage backend for the endpoint. The backend is selected
+// with the scheme of the endpoint, endpoints without a scheme are considered to be
+// Postgres connection strings.
+func NewStore(endpoint string) (Store, error) {
+	switch scheme := endpointScheme(endpoint); scheme {
+	case "postgres", "postgresql":
+		return NewState(endpoint)
+
+	case "sqlite", "sqlite3":
+		return NewSQLiteState(endpoint)
+
+	case "memory":
+		return NewMemoryState(), nil
+
+	default:
+		return nil, fmt.Errorf("storage backend '%s' not supported", scheme)
+	}
+}
+
+func endpointScheme(endpoint string) string {
+	if indx := strings.Index(endpoint, "://"); indx != -1 {
+		return endpoint[:indx]
+	}
+	return "postgres"
+}
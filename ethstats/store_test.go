@@ -0,0 +1,809 @@
+package ethstats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// storeTests are the tests that every Store backend has to pass
+var storeTests = []struct {
+	name string
+	fn   func(t *testing.T, s Store)
+}{
+	{"WriteBlock", testStoreWriteBlock},
+	{"DeleteOlderData", testStoreDeleteOlderData},
+	{"DeleteRows", testStoreDeleteRows},
+	{"NodeInfo", testStoreNodeInfo},
+	{"NodeStats", testStoreNodeStats},
+	{"HeadEvent", testStoreHeadEvent},
+	{"ListBlocks", testStoreListBlocks},
+	{"ListBlockNumbers", testStoreListBlockNumbers},
+	{"ListNodes", testStoreListNodes},
+	{"ListHeadEvents", testStoreListHeadEvents},
+	{"Reorgs", testStoreReorgs},
+	{"ChainSplits", testStoreChainSplits},
+	{"BlockStatus", testStoreBlockStatus},
+	{"BlockArrivals", testStoreBlockArrivals},
+	{"NodeLatency", testStoreNodeLatency},
+	{"NodePending", testStoreNodePending},
+	{"NodeStatsHistory", testStoreNodeStatsHistory},
+	{"Rollups", testStoreRollups},
+	{"RecomputeRollups", testStoreRecomputeRollups},
+	{"SessionEvents", testStoreSessionEvents},
+	{"SetNodeActive", testStoreSetNodeActive},
+}
+
+func runStoreTests(t *testing.T, factory func(t *testing.T) (Store, func())) {
+	for _, c := range storeTests {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			s, closeFn := factory(t)
+			defer closeFn()
+
+			c.fn(t, s)
+		})
+	}
+}
+
+func TestMemoryState(t *testing.T) {
+	runStoreTests(t, func(t *testing.T) (Store, func()) {
+		s := NewMemoryState()
+		return s, s.Close
+	})
+}
+
+func TestNewStore(t *testing.T) {
+	s, err := NewStore("memory://")
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryState{}, s)
+
+	_, err = NewStore("mongodb://localhost")
+	assert.Error(t, err)
+}
+
+func testStoreWriteBlock(t *testing.T, s Store) {
+	hash := "0x1234"
+
+	block := &Block{
+		Number:    99999,
+		Hash:      hash,
+		Timestamp: time.Now().Nanosecond(),
+		Txs:       []TxStats{{Hash: "0x0"}},
+		Diff:      argBigPtr(one),
+	}
+	assert.NoError(t, s.WriteBlock(config, block))
+
+	// the block is only written once
+	assert.NoError(t, s.WriteBlock(config, block))
+
+	block2, err := s.GetBlock(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Number, block2.Number)
+	assert.Len(t, block2.Txs, 1)
+
+	block3, err := s.GetBlock("0x5678")
+	assert.NoError(t, err)
+	assert.Nil(t, block3)
+}
+
+func testStoreDeleteOlderData(t *testing.T, s Store) {
+	hashA, hashB := "0x1234", "0x1235"
+
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 1, Hash: hashA, Txs: []TxStats{{Hash: "0x0"}}}))
+	assert.NoError(t, s.WriteNodeStats("a", &NodeStats{Peers: 1}))
+
+	time.Sleep(2 * time.Second)
+
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 2, Hash: hashB, Txs: []TxStats{{Hash: "0x1"}, {Hash: "0x2"}}}))
+	assert.NoError(t, s.WriteNodeStats("a", &NodeStats{Peers: 2}))
+
+	assert.NoError(t, s.DeleteOlderData(2))
+
+	blockA, err := s.GetBlock(hashA)
+	assert.NoError(t, err)
+	assert.Nil(t, blockA)
+
+	blockB, err := s.GetBlock(hashB)
+	assert.NoError(t, err)
+	assert.Len(t, blockB.Txs, 2)
+
+	// the stats history is purged but not the latest stats
+	history, err := s.ListNodeStatsHistory("a", time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, history[0].Peers, 2)
+
+	stats, err := s.GetNodeStats("a")
+	assert.NoError(t, err)
+	assert.Equal(t, stats.Peers, 2)
+}
+
+func testStoreDeleteRows(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 1, Hash: "0x1", Txs: []TxStats{{Hash: "0xa"}, {Hash: "0xb"}, {Hash: "0xc"}}}))
+	assert.NoError(t, s.WriteNodeStats("a", &NodeStats{Peers: 1}))
+	_, err := s.WriteHeadEvent("a", &HeadEvent{Added: []BlockStub{{Hash: "0x1", Number: 1}}})
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 2, Hash: "0x2", Txs: []TxStats{{Hash: "0xd"}}}))
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 3, Hash: "0x3"}))
+
+	cutoff := Cutoff{Age: time.Second}
+
+	count, err := s.CountRows(TableBlockTxs, cutoff)
+	assert.NoError(t, err)
+	assert.Equal(t, count, 3)
+
+	// the rows are deleted in batches
+	for _, expected := range []int{2, 1, 0} {
+		deleted, err := s.DeleteRows(TableBlockTxs, cutoff, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, deleted, expected)
+	}
+	block, err := s.GetBlock("0x1")
+	assert.NoError(t, err)
+	assert.Len(t, block.Txs, 0)
+
+	deleted, err := s.DeleteRows(TableBlocks, cutoff, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, 1)
+
+	block, err = s.GetBlock("0x2")
+	assert.NoError(t, err)
+	assert.Len(t, block.Txs, 1)
+
+	// by block number
+	count, err = s.CountRows(TableBlocks, Cutoff{BlockNumber: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, count, 1)
+
+	deleted, err = s.DeleteRows(TableBlocks, Cutoff{BlockNumber: 3}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, 1)
+
+	count, err = s.CountRows(TableHeadEvents, Cutoff{BlockNumber: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, count, 0)
+
+	deleted, err = s.DeleteRows(TableHeadEvents, Cutoff{BlockNumber: 2}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, 1)
+
+	// node 'a' is referenced by its stats history
+	count, err = s.CountRows(TableNodeInfo, cutoff)
+	assert.NoError(t, err)
+	assert.Equal(t, count, 1)
+
+	deleted, err = s.DeleteRows(TableNodeStatsHistory, cutoff, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, 1)
+
+	deleted, err = s.DeleteRows(TableNodeInfo, cutoff, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, 2)
+
+	info, err := s.GetNodeInfo("a")
+	assert.NoError(t, err)
+	assert.Nil(t, info)
+
+	stats, err := s.GetNodeStats("a")
+	assert.NoError(t, err)
+	assert.Nil(t, stats)
+
+	count, err = s.CountRows(TableNodeStats, Cutoff{})
+	assert.NoError(t, err)
+	assert.Equal(t, count, 0)
+
+	_, err = s.DeleteRows(TableNodeStatsHistory, Cutoff{BlockNumber: 10}, 10)
+	assert.Error(t, err)
+
+	_, err = s.DeleteRows(TableBlocks, Cutoff{}, 10)
+	assert.Error(t, err)
+
+	_, err = s.DeleteRows("chain_splits", cutoff, 10)
+	assert.Error(t, err)
+}
+
+func testStoreNodeInfo(t *testing.T, s Store) {
+	info := &NodeInfo{
+		Name: "a",
+		Node: "b",
+	}
+	assert.NoError(t, s.WriteNodeInfo(info))
+
+	info2, err := s.GetNodeInfo("a")
+	assert.NoError(t, err)
+
+	info2.CreatedAt = time.Time{}
+	assert.Equal(t, info, info2)
+
+	// get stats should be available but empty
+	stats, err := s.GetNodeStats("a")
+	assert.NoError(t, err)
+	assert.NotNil(t, stats)
+
+	// node id is required
+	assert.Error(t, s.WriteNodeInfo(&NodeInfo{}))
+}
+
+func testStoreNodeStats(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+
+	stats := &NodeStats{
+		Peers: 100,
+	}
+	assert.NoError(t, s.WriteNodeStats("b", stats))
+
+	stats2, err := s.GetNodeStats("b")
+	assert.NoError(t, err)
+	assert.Equal(t, stats, stats2)
+}
+
+func testStoreHeadEvent(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+
+	evnt := &HeadEvent{
+		Added: []BlockStub{
+			{Hash: "0x1", Number: 1},
+		},
+		Removed: []BlockStub{
+			{Hash: "0x1", Number: 1},
+		},
+		Type: "fork",
+	}
+
+	eventID, err := s.WriteHeadEvent("b", evnt)
+	assert.NoError(t, err)
+
+	evnt2, err := s.GetHeadEvent(eventID)
+	assert.NoError(t, err)
+	assert.Equal(t, evnt, evnt2)
+}
+
+func testStoreListBlocks(t *testing.T, s Store) {
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, s.WriteBlock(config, &Block{Number: i, Hash: fmt.Sprintf("0x%d", i)}))
+	}
+
+	blocks, err := s.ListBlocks(2, 4, 10)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 3)
+	assert.Equal(t, blocks[0].Hash, "0x4")
+	assert.Equal(t, blocks[2].Hash, "0x2")
+
+	blocks, err = s.ListBlocks(0, 100, 2)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+	assert.Equal(t, blocks[0].Number, 5)
+
+	blocks, err = s.ListBlocks(10, 100, 2)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 0)
+}
+
+func testStoreListBlockNumbers(t *testing.T, s Store) {
+	for _, hash := range []string{"0x1", "0x3a", "0x3b", "0x4"} {
+		number := int(hash[2] - '0')
+		assert.NoError(t, s.WriteBlock(config, &Block{Number: number, Hash: hash}))
+	}
+
+	numbers, err := s.ListBlockNumbers(0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, numbers, []int{1, 3, 4})
+
+	numbers, err = s.ListBlockNumbers(2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, numbers, []int{3})
+}
+
+func testStoreListNodes(t *testing.T, s Store) {
+	nodes, err := s.ListNodes()
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 0)
+
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+
+	nodes, err = s.ListNodes()
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 2)
+	assert.Equal(t, nodes[0].Name, "a")
+	assert.Equal(t, nodes[1].Name, "b")
+}
+
+func testStoreListHeadEvents(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		id, err := s.WriteHeadEvent("a", &HeadEvent{
+			Type:  "head",
+			Added: []BlockStub{{Hash: fmt.Sprintf("0x%d", i), Number: i}},
+		})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+
+		// ulids are only sorted across milliseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+	_, err := s.WriteHeadEvent("b", &HeadEvent{Type: "head"})
+	assert.NoError(t, err)
+
+	events, err := s.ListHeadEvents("", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 4)
+
+	events, err = s.ListHeadEvents("a", "", 2)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, events[0].ID, ids[2])
+	assert.Equal(t, events[0].NodeID, "a")
+	assert.Equal(t, events[0].Added, []BlockStub{{Hash: "0x2", Number: 2}})
+	assert.Equal(t, events[0].Removed, []BlockStub{})
+
+	// next page
+	events, err = s.ListHeadEvents("a", events[1].ID, 2)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, events[0].ID, ids[0])
+}
+
+func testStoreReorgs(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+
+	evnt := &HeadEvent{
+		Added:   []BlockStub{{Hash: "0x2b", ParentHash: "0x1", Number: 2}},
+		Removed: []BlockStub{{Hash: "0x2a", ParentHash: "0x1", Number: 2}},
+	}
+
+	ids := []string{}
+	for _, node := range []string{"a", "a", "b"} {
+		id, err := s.WriteHeadEvent(node, evnt)
+		assert.NoError(t, err)
+		assert.NoError(t, s.WriteReorg(detectReorg(node, id, evnt)))
+		ids = append(ids, id)
+
+		// ulids are only sorted across milliseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	reorgs, err := s.ListReorgs("", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, reorgs, 3)
+
+	reorgs, err = s.ListReorgs("a", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, reorgs, 2)
+	assert.Equal(t, reorgs[0].EventID, ids[1])
+	assert.Equal(t, reorgs[0].CommonAncestorHash, "0x1")
+	assert.Equal(t, reorgs[0].Depth, 1)
+	assert.Equal(t, reorgs[0].Dropped, hashList{"0x2a"})
+	assert.Equal(t, reorgs[0].Added, hashList{"0x2b"})
+	assert.False(t, reorgs[0].DetectedAt.IsZero())
+
+	reorgs, err = s.ListReorgs("a", ids[1], 10)
+	assert.NoError(t, err)
+	assert.Len(t, reorgs, 1)
+	assert.Equal(t, reorgs[0].EventID, ids[0])
+}
+
+func testStoreChainSplits(t *testing.T, s Store) {
+	split := &ChainSplit{
+		ID:         "01",
+		Height:     2,
+		ForkNumber: 1,
+		ForkHash:   "0x1",
+		Branches:   splitBranches{"0x2a": {"a"}, "0x2b": {"b"}},
+		DetectedAt: time.Now().UTC(),
+	}
+	assert.NoError(t, s.WriteChainSplit(split))
+	assert.NoError(t, s.WriteChainSplit(&ChainSplit{ID: "02", Branches: splitBranches{}, DetectedAt: time.Now().UTC()}))
+
+	splits, err := s.ListChainSplits("", 10)
+	assert.NoError(t, err)
+	assert.Len(t, splits, 2)
+	assert.Equal(t, splits[0].ID, "02")
+
+	// resolve the split
+	resolvedAt := time.Now().UTC()
+	split.ResolvedAt = &resolvedAt
+	split.Branches = splitBranches{"0x2a": {"a", "b"}}
+	assert.NoError(t, s.WriteChainSplit(split))
+
+	splits, err = s.ListChainSplits("02", 10)
+	assert.NoError(t, err)
+	assert.Len(t, splits, 1)
+	assert.Equal(t, splits[0].ForkHash, "0x1")
+	assert.Equal(t, splits[0].Branches, splitBranches{"0x2a": {"a", "b"}})
+	assert.True(t, splits[0].Resolved())
+}
+
+func testStoreBlockStatus(t *testing.T, s Store) {
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.WriteBlock(config, &Block{Number: i, Hash: fmt.Sprintf("0x%d", i)}))
+	}
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 3, Hash: "0x3b"}))
+
+	block, err := s.GetBlock("0x1")
+	assert.NoError(t, err)
+	assert.Equal(t, block.Status, BlockStatusUnknown)
+	assert.False(t, block.Finalized)
+
+	head, err := s.GetCanonicalHead()
+	assert.NoError(t, err)
+	assert.Nil(t, head)
+
+	for _, hash := range []string{"0x1", "0x2", "0x3"} {
+		assert.NoError(t, s.SetBlockStatus(hash, BlockStatusCanonical))
+	}
+	assert.NoError(t, s.SetBlockStatus("0x3b", BlockStatusOrphaned))
+
+	block, err = s.GetCanonicalBlock(3)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, "0x3")
+	assert.Equal(t, block.Status, BlockStatusCanonical)
+
+	head, err = s.GetCanonicalHead()
+	assert.NoError(t, err)
+	assert.Equal(t, head.Hash, "0x3")
+
+	block, err = s.GetCanonicalBlock(4)
+	assert.NoError(t, err)
+	assert.Nil(t, block)
+
+	// the status of the finalized blocks does not change
+	assert.NoError(t, s.FinalizeBlocks(2))
+	assert.NoError(t, s.SetBlockStatus("0x2", BlockStatusOrphaned))
+
+	block, err = s.GetBlock("0x2")
+	assert.NoError(t, err)
+	assert.Equal(t, block.Status, BlockStatusCanonical)
+	assert.True(t, block.Finalized)
+
+	block, err = s.GetBlock("0x3")
+	assert.NoError(t, err)
+	assert.False(t, block.Finalized)
+
+	blocks, err := s.ListBlocks(3, 3, 10)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+	assert.Equal(t, blocks[0].Status, BlockStatusCanonical)
+	assert.Equal(t, blocks[1].Status, BlockStatusOrphaned)
+}
+
+func testStoreBlockArrivals(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+
+	now := time.Now().UTC()
+	arrivals := []*BlockArrival{
+		{NodeID: "b", BlockHash: "0x1", BlockNumber: 1, BlockTimestamp: 100, ReceivedAt: now.Add(-time.Hour)},
+		{NodeID: "a", BlockHash: "0x1", BlockNumber: 1, BlockTimestamp: 100, ReceivedAt: now.Add(-time.Hour).Add(time.Second)},
+		{NodeID: "a", BlockHash: "0x2", BlockNumber: 2, BlockTimestamp: 102, ReceivedAt: now},
+	}
+	for _, arrival := range arrivals {
+		assert.NoError(t, s.WriteBlockArrival(arrival))
+	}
+
+	// only the first arrival of each node is kept
+	assert.NoError(t, s.WriteBlockArrival(&BlockArrival{NodeID: "a", BlockHash: "0x2", BlockNumber: 2, ReceivedAt: now.Add(time.Second)}))
+
+	res, err := s.GetBlockArrivals("0x1")
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, res[0].NodeID, "b")
+	assert.Equal(t, res[0].BlockTimestamp, 100)
+	assert.Equal(t, res[1].NodeID, "a")
+
+	res, err = s.ListBlockArrivals(2, 5)
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.True(t, res[0].ReceivedAt.Equal(now))
+
+	// the arrivals are purged by their reception time
+	assert.NoError(t, s.DeleteOlderData(60))
+
+	res, err = s.ListBlockArrivals(0, 5)
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, res[0].BlockHash, "0x2")
+}
+
+func testStoreNodeLatency(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "b"}))
+
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		reportedAt := now.Add(-time.Duration(i) * time.Hour)
+		assert.NoError(t, s.WriteNodeLatency(&NodeLatency{NodeID: "a", Latency: 10 + i, ReportedAt: reportedAt}))
+	}
+	assert.NoError(t, s.WriteNodeLatency(&NodeLatency{NodeID: "b", Latency: 50, ReportedAt: now}))
+
+	latencies, err := s.ListNodeLatency("a", time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, latencies, 3)
+	assert.Equal(t, latencies[0].Latency, 10)
+	assert.Equal(t, latencies[2].Latency, 12)
+
+	latencies, err = s.ListNodeLatency("a", now.Add(-90*time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Len(t, latencies, 2)
+
+	latencies, err = s.ListNodeLatency("a", time.Time{}, 1)
+	assert.NoError(t, err)
+	assert.Len(t, latencies, 1)
+
+	// the latencies are purged by the time they were reported
+	assert.NoError(t, s.DeleteOlderData(60))
+
+	latencies, err = s.ListNodeLatency("a", time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, latencies, 1)
+}
+
+func testStoreNodePending(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+
+	pending, err := s.GetNodePending("a")
+	assert.NoError(t, err)
+	assert.Nil(t, pending)
+
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		reportedAt := now.Add(-time.Duration(i) * time.Hour)
+		assert.NoError(t, s.WriteNodePending(&NodePending{NodeID: "a", Pending: 100 + i, ReportedAt: reportedAt}))
+	}
+
+	pending, err = s.GetNodePending("a")
+	assert.NoError(t, err)
+	assert.Equal(t, pending.Pending, 100)
+
+	history, err := s.ListNodePending("a", now.Add(-90*time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, history[1].Pending, 101)
+
+	// the pending counts are purged by the time they were reported
+	assert.NoError(t, s.DeleteOlderData(60))
+
+	history, err = s.ListNodePending("a", time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func testStoreNodeStatsHistory(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+
+	start := time.Now()
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.WriteNodeStats("a", &NodeStats{Active: true, Peers: i}))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the stats of unknown nodes are not recorded
+	assert.NoError(t, s.WriteNodeStats("b", &NodeStats{Peers: 10}))
+
+	history, err := s.ListNodeStatsHistory("a", time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, history[0].Peers, 1)
+	assert.Equal(t, history[2].Peers, 3)
+	assert.True(t, history[2].Active)
+	assert.Equal(t, history[2].NodeID, "a")
+
+	history, err = s.ListNodeStatsHistory("a", history[1].ReportedAt, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	history, err = s.ListNodeStatsHistory("a", time.Time{}, history[0].ReportedAt, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	history, err = s.ListNodeStatsHistory("b", time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 0)
+
+	// stats at a given time
+	snapshot, err := s.GetNodeStatsAt("a", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot.Peers, 3)
+
+	snapshot, err = s.GetNodeStatsAt("a", start.Add(-time.Second))
+	assert.NoError(t, err)
+	assert.Nil(t, snapshot)
+}
+
+func testStoreRollups(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, s.WriteNodeStats("a", &NodeStats{Active: true, Peers: 2, Uptime: 100}))
+	assert.NoError(t, s.WriteNodeStats("a", &NodeStats{Active: false, Peers: 4, Uptime: 50}))
+
+	history, err := s.ListNodeStatsHistory("a", time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	bucket := RollupHourly.truncate(history[0].ReportedAt)
+
+	last, err := s.LastRollup(RollupHourly)
+	assert.NoError(t, err)
+	assert.True(t, last.IsZero())
+
+	blocks := []*Block{
+		{Number: 1, Hash: "0x1", Timestamp: int(bucket.Unix()) + 10, GasUsed: 50, GasLimit: 100},
+		{Number: 2, Hash: "0x2", Timestamp: int(bucket.Unix()) + 12, GasUsed: 25, GasLimit: 100},
+		{Number: 2, Hash: "0x2b", Timestamp: int(bucket.Unix()) + 14, GasUsed: 100, GasLimit: 100},
+		{Number: 3, Hash: "0x3", Timestamp: int(bucket.Unix()) + 16, GasUsed: 75, GasLimit: 100},
+		// previous bucket
+		{Number: 0, Hash: "0x0", Timestamp: int(bucket.Unix()) - 2, GasUsed: 100, GasLimit: 100},
+	}
+	for _, block := range blocks {
+		assert.NoError(t, s.WriteBlock(config, block))
+	}
+	assert.NoError(t, s.SetBlockStatus("0x2b", BlockStatusOrphaned))
+
+	evnt := &HeadEvent{
+		Added:   []BlockStub{{Hash: "0x2", ParentHash: "0x1", Number: 2}},
+		Removed: []BlockStub{{Hash: "0x2b", ParentHash: "0x1", Number: 2}},
+	}
+	id, err := s.WriteHeadEvent("a", evnt)
+	assert.NoError(t, err)
+	reorg := detectReorg("a", id, evnt)
+	reorg.DetectedAt = bucket.Add(time.Minute)
+	assert.NoError(t, s.WriteReorg(reorg))
+
+	assert.NoError(t, s.WriteRollup(RollupHourly, bucket))
+	// the rollups can be recomputed
+	assert.NoError(t, s.WriteRollup(RollupHourly, bucket.Add(10*time.Minute)))
+
+	last, err = s.LastRollup(RollupHourly)
+	assert.NoError(t, err)
+	assert.True(t, last.Equal(bucket))
+
+	chain, err := s.ListChainRollups(RollupHourly, time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 1)
+	assert.True(t, chain[0].Bucket.Equal(bucket))
+	assert.Equal(t, chain[0].BlockCount, 3)
+	assert.InDelta(t, chain[0].AvgGasRatio, 0.5, 0.0001)
+	assert.InDelta(t, chain[0].AvgBlockTime, 3, 0.0001)
+	assert.Equal(t, chain[0].ReorgCount, 1)
+
+	chain, err = s.ListChainRollups(RollupDaily, time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 0)
+
+	chain, err = s.ListChainRollups(RollupHourly, bucket.Add(time.Second), time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 0)
+
+	nodes, err := s.ListNodeRollups("a", RollupHourly, time.Time{}, bucket.Add(time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, nodes[0].Samples, 2)
+	assert.InDelta(t, nodes[0].AvgPeers, 3, 0.0001)
+	assert.InDelta(t, nodes[0].AvgUptime, 75, 0.0001)
+	assert.InDelta(t, nodes[0].ActiveRatio, 0.5, 0.0001)
+
+	nodes, err = s.ListNodeRollups("b", RollupHourly, time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 0)
+
+	nodes, err = s.ListNodeRollups("a", RollupHourly, time.Time{}, bucket, 10)
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 0)
+
+	// the newest rollups are returned up to the limit and
+	// the older ones with the bucket of the last one as before
+	assert.NoError(t, s.WriteRollup(RollupHourly, bucket.Add(-time.Hour)))
+	chain, err = s.ListChainRollups(RollupHourly, time.Time{}, time.Time{}, 1)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 1)
+	assert.True(t, chain[0].Bucket.Equal(bucket))
+
+	chain, err = s.ListChainRollups(RollupHourly, time.Time{}, chain[0].Bucket, 1)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 1)
+	assert.True(t, chain[0].Bucket.Equal(bucket.Add(-time.Hour)))
+	assert.Equal(t, chain[0].BlockCount, 1)
+}
+
+func testStoreRecomputeRollups(t *testing.T, s Store) {
+	now := time.Now()
+	retention := RetentionConfig{Blocks: 2 * time.Hour}
+
+	old := RollupHourly.truncate(now.Add(-3 * time.Hour))
+	recent := RollupHourly.truncate(now.Add(-time.Hour))
+
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 1, Hash: "0x1", Timestamp: int(old.Unix()) + 10}))
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 2, Hash: "0x2", Timestamp: int(old.Unix()) + 12}))
+	assert.NoError(t, s.WriteRollup(RollupHourly, old))
+	assert.NoError(t, s.WriteRollup(RollupHourly, recent))
+
+	// the blocks of the old bucket are purged
+	deleted, err := s.DeleteRows(TableBlocks, Cutoff{Age: time.Nanosecond}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, 2)
+
+	// late blocks are written in both buckets
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 3, Hash: "0x3", Timestamp: int(old.Unix()) + 14}))
+	assert.NoError(t, s.WriteBlock(config, &Block{Number: 4, Hash: "0x4", Timestamp: int(recent.Unix()) + 10}))
+
+	stale := map[RollupPeriod][]time.Time{RollupHourly: {old, recent}}
+	count, err := recomputeRollups(s, stale, retention.rollupCutoff(now))
+	assert.NoError(t, err)
+	assert.Equal(t, count, 1)
+
+	// the bucket older than the retention keeps the blocks purged
+	chain, err := s.ListChainRollups(RollupHourly, old, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 2)
+	assert.True(t, chain[0].Bucket.Equal(recent))
+	assert.Equal(t, chain[0].BlockCount, 1)
+	assert.True(t, chain[1].Bucket.Equal(old))
+	assert.Equal(t, chain[1].BlockCount, 2)
+}
+
+func testStoreSessionEvents(t *testing.T, s Store) {
+	now := time.Now().UTC()
+	for i, node := range []string{"a", "b", "a"} {
+		evnt := &SessionEvent{
+			ID:         fmt.Sprintf("0%d", i+1),
+			NodeID:     node,
+			Type:       SessionConnected,
+			RemoteAddr: "127.0.0.1:3000",
+			CreatedAt:  now.Add(-time.Duration(2-i) * time.Hour),
+		}
+		assert.NoError(t, s.WriteSessionEvent(evnt))
+	}
+
+	events, err := s.ListSessionEvents("", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, events[0].ID, "03")
+	assert.Equal(t, events[0].RemoteAddr, "127.0.0.1:3000")
+
+	events, err = s.ListSessionEvents("a", "03", 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, events[0].ID, "01")
+
+	// the events are purged by the time they were written
+	deleted, err := s.DeleteRows(TableSessionEvents, Cutoff{Age: 90 * time.Minute}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, 1)
+
+	events, err = s.ListSessionEvents("", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+}
+
+func testStoreSetNodeActive(t *testing.T, s Store) {
+	assert.NoError(t, s.WriteNodeInfo(&NodeInfo{Name: "a"}))
+	assert.NoError(t, s.WriteNodeStats("a", &NodeStats{Active: true, Peers: 5}))
+
+	assert.NoError(t, s.SetNodeActive("a", false))
+
+	// the other stats are kept and the history is not modified
+	stats, err := s.GetNodeStats("a")
+	assert.NoError(t, err)
+	assert.False(t, stats.Active)
+	assert.Equal(t, stats.Peers, 5)
+
+	history, err := s.ListNodeStatsHistory("a", time.Time{}, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.True(t, history[0].Active)
+
+	// unknown nodes are ignored
+	assert.NoError(t, s.SetNodeActive("b", false))
+}
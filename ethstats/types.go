@@ -29,6 +29,10 @@ type Block struct {
 	TxHash     string    `json:"transactionsRoot" db:"transactions_root"`
 	Root       string    `json:"stateRoot" db:"state_root"`
 	Uncles     []Block   `json:"uncles"`
+
+	// Status and Finalized are set by the collector while it tracks the canonical chain
+	Status    string `json:"status" db:"status"`
+	Finalized bool   `json:"finalized" db:"finalized"`
 }
 
 // nodeInfo is the collection of meta information about a node that is displayed
@@ -44,7 +48,7 @@ type NodeInfo struct {
 	OsVer     string    `json:"os_v" db:"osver"`
 	Client    string    `json:"client" db:"client"`
 	History   bool      `json:"canUpdateHistory" db:"history"`
-	CreatedAt time.Time `db:"created_at"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 }
 
 // nodeStats is the information to report about the local node.
@@ -58,12 +62,45 @@ type NodeStats struct {
 	Uptime   int  `json:"uptime" db:"uptime"`
 }
 
+// NodeStatsSnapshot are the stats reported by a node at a given time
+type NodeStatsSnapshot struct {
+	NodeStats
+
+	NodeID     string    `json:"node" db:"node_id"`
+	ReportedAt time.Time `json:"reportedAt" db:"reported_at"`
+}
+
+// NodeLatency is the latency between a node and the collector reported by the node
+type NodeLatency struct {
+	NodeID string `json:"node" db:"node_id"`
+
+	// Latency is the round-trip latency of the node-ping in milliseconds
+	Latency    int       `json:"latency" db:"latency"`
+	ReportedAt time.Time `json:"reportedAt" db:"reported_at"`
+}
+
+// NodePending is the number of pending transactions reported by a node
+type NodePending struct {
+	NodeID     string    `json:"node" db:"node_id"`
+	Pending    int       `json:"pending" db:"pending"`
+	ReportedAt time.Time `json:"reportedAt" db:"reported_at"`
+}
+
 type HeadEvent struct {
 	Added   []BlockStub `json:"added"`
 	Removed []BlockStub `json:"removed"`
 	Type    string      `json:"type" db:"typ"`
 }
 
+// NodeHeadEvent is a head event stored for a node
+type NodeHeadEvent struct {
+	*HeadEvent
+
+	ID        string    `json:"id" db:"event_id"`
+	NodeID    string    `json:"node" db:"node_id"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
 type BlockStub struct {
 	ParentHash string `json:"parent_hash" db:"parent_hash"`
 	Hash       string `json:"hash" db:"block_hash"`
@@ -92,6 +129,10 @@ func (a *argBig) Scan(value interface{}) error {
 	return fmt.Errorf("cannot convert to big.Int (%s)", reflect.TypeOf(value))
 }
 
+func (a *argBig) MarshalText() ([]byte, error) {
+	return []byte((*big.Int)(a).String()), nil
+}
+
 func (a *argBig) UnmarshalText(input []byte) error {
 	str := string(input)
 	base := 10
@@ -108,3 +149,23 @@ func (a *argBig) UnmarshalText(input []byte) error {
 	*a = argBig(*big)
 	return nil
 }
+
+// hashList is a list of hashes stored as a comma separated string
+type hashList []string
+
+func (h hashList) Value() (driver.Value, error) {
+	return strings.Join(h, ","), nil
+}
+
+func (h *hashList) Scan(value interface{}) error {
+	var str sql.NullString
+	if err := str.Scan(value); err != nil {
+		return err
+	}
+	if str.String == "" {
+		*h = hashList{}
+	} else {
+		*h = strings.Split(str.String, ",")
+	}
+	return nil
+}
@@ -22,3 +22,18 @@ func TestTypes_Block(t *testing.T) {
 	assert.Equal(t, b.Diff, num)
 	assert.Equal(t, b.TotalDiff, num)
 }
+
+func TestTypes_BlockMarshal(t *testing.T) {
+	b := &Block{
+		Diff:      argBigPtr(big.NewInt(4112)),
+		TotalDiff: argBigPtr(big.NewInt(1)),
+	}
+
+	data, err := json.Marshal(b)
+	assert.NoError(t, err)
+
+	var b2 Block
+	assert.NoError(t, json.Unmarshal(data, &b2))
+	assert.Equal(t, b.Diff, b2.Diff)
+	assert.Equal(t, b.TotalDiff, b2.TotalDiff)
+}
@@ -1,20 +1,235 @@
 package ethstats
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/hashicorp/go-hclog"
 )
 
-var upgrader = websocket.Upgrader{} // use default options
+// upgrader accepts the websocket connections of the nodes from any origin. It is
+// shared by the connections of every server, so it is not modified after init.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// wsWriter writes messages to a websocket connection
+type wsWriter interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
+// wsSession is the connection with an authenticated node. Both the collector
+// and the proxy write to the connection so the writes are serialized.
+type wsSession struct {
+	nodeID string
+	conn   wsWriter
+	lock   sync.Mutex
+
+	// proxiesLock protects the proxies since they change when the frontends are reloaded
+	proxiesLock sync.RWMutex
+
+	// proxies are the proxies of the node messages to the frontends
+	proxies []*wsProxy
+
+	// hello is the hello of the node (raw and decoded) sent to the proxies
+	hello     *Msg
+	helloData []byte
+
+	// proxiesClosed is set once the session is closed, so no new proxies are started
+	proxiesClosed bool
+
+	// secret is the secret the node authenticated with
+	secret string
+
+	remoteAddr  string
+	connectedAt time.Time
+
+	// activityLock protects the activity of the session
+	activityLock  sync.Mutex
+	lastMessageAt time.Time
+	messages      map[string]int
+	reason        string
+}
+
+func newWsSession(nodeID string, conn wsWriter) *wsSession {
+	return &wsSession{
+		nodeID:      nodeID,
+		conn:        conn,
+		connectedAt: time.Now().UTC(),
+		messages:    map[string]int{},
+	}
+}
+
+// received records a message received from the node
+func (s *wsSession) received(typ string) {
+	s.activityLock.Lock()
+	defer s.activityLock.Unlock()
+
+	s.lastMessageAt = time.Now().UTC()
+	s.messages[typ]++
+}
+
+// info returns the activity of the session
+func (s *wsSession) info() *SessionInfo {
+	s.activityLock.Lock()
+	defer s.activityLock.Unlock()
+
+	info := &SessionInfo{
+		NodeID:      s.nodeID,
+		RemoteAddr:  s.remoteAddr,
+		ConnectedAt: s.connectedAt,
+		Messages:    map[string]int{},
+	}
+	if !s.lastMessageAt.IsZero() {
+		lastMessageAt := s.lastMessageAt
+		info.LastMessageAt = &lastMessageAt
+	}
+	for typ, num := range s.messages {
+		info.Messages[typ] = num
+	}
+	return info
+}
+
+// setCloseReason sets why the session is closed, only the first reason is kept
+func (s *wsSession) setCloseReason(reason string) {
+	s.activityLock.Lock()
+	defer s.activityLock.Unlock()
+
+	if s.reason == "" {
+		s.reason = reason
+	}
+}
+
+func (s *wsSession) closeReason() string {
+	s.activityLock.Lock()
+	defer s.activityLock.Unlock()
+
+	return s.reason
+}
+
+func (s *wsSession) WriteMessage(messageType int, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.conn.WriteMessage(messageType, data)
+}
+
+// close closes the connection with the node for the given reason
+func (s *wsSession) close(reason string) {
+	s.setCloseReason(reason)
+	if closer, ok := s.conn.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// getProxies returns the current proxies of the session
+func (s *wsSession) getProxies() []*wsProxy {
+	s.proxiesLock.RLock()
+	defer s.proxiesLock.RUnlock()
+
+	return s.proxies
+}
+
+// setHello sets the hello of the node the proxies start with
+func (s *wsSession) setHello(hello *Msg, data []byte) {
+	s.proxiesLock.Lock()
+	defer s.proxiesLock.Unlock()
+
+	s.hello = hello
+	s.helloData = data
+}
+
+// closeProxies stops the proxies of the session and waits for them to
+// send the queued messages to the frontends
+func (s *wsSession) closeProxies() {
+	s.proxiesLock.Lock()
+	proxies := s.proxies
+	for _, proxy := range proxies {
+		proxy.close()
+	}
+	s.proxies = nil
+	s.proxiesClosed = true
+	s.proxiesLock.Unlock()
+
+	for _, proxy := range proxies {
+		<-proxy.doneCh
+	}
+}
+
+// goAway sends a close frame to the node, the session is closed once the node replies
+func (s *wsSession) goAway(reason string) error {
+	s.setCloseReason(reason)
+	return s.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+}
+
+// send writes an emit message to the node
+func (s *wsSession) send(msg *Msg) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	return s.WriteMessage(websocket.TextMessage, data)
+}
+
+const (
+	// proxyQueueSize is the number of messages queued per proxy while the frontend is down
+	proxyQueueSize = 1000
+
+	// proxyFlushTimeout is the time to send the queued messages to the frontend once the proxy is closed
+	proxyFlushTimeout = 5 * time.Second
+
+	// proxyMinBackoff and proxyMaxBackoff bound the wait between the attempts to connect to the frontend
+	proxyMinBackoff = 500 * time.Millisecond
+	proxyMaxBackoff = 30 * time.Second
+)
+
+// proxyReplayTypes are the message types whose latest message is sent again after a
+// reconnect, so the frontend gets the current state of the node without waiting for it
+var proxyReplayTypes = []string{"block", "stats"}
+
+// proxyBackoff returns the wait before the given attempt (starting at 0) to connect to
+// the frontend. The wait doubles on each attempt, with a random jitter of up to half of it.
+func proxyBackoff(attempt int) time.Duration {
+	backoff := proxyMaxBackoff
+	if attempt < 16 {
+		if b := proxyMinBackoff << uint(attempt); b < proxyMaxBackoff {
+			backoff = b
+		}
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + mrand.Int63n(half+1))
+}
+
+// ProxyState is the state of the proxy of a node to a frontend
+type ProxyState struct {
+	NodeID      string     `json:"node"`
+	Frontend    string     `json:"frontend"`
+	Addr        string     `json:"addr"`
+	Connected   bool       `json:"connected"`
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	QueueDepth  int        `json:"queueDepth"`
+	QueueSize   int        `json:"queueSize"`
+	Sent        uint64     `json:"sent"`
+	Dropped     uint64     `json:"dropped"`
+	Reconnects  uint64     `json:"reconnects"`
+}
 
 type wsProxy struct {
 	logger hclog.Logger
 
 	// connection with the Bor client
-	downstream *websocket.Conn
+	downstream wsWriter
 
 	// connection to the proxy frontend server
 	upstream *websocket.Conn
@@ -25,11 +240,37 @@ type wsProxy struct {
 	// close channel to stop the proxy
 	closeCh chan struct{}
 
+	// doneCh is closed once the proxy is stopped
+	doneCh chan struct{}
+
 	// channel to proxy messages
 	msgCh chan []byte
+
+	// nodeID is the id of the node whose messages are proxied
+	nodeID string
+
+	// frontend is the name of the frontend the messages are proxied to
+	frontend string
+
+	// target is the frontend the proxy was created for
+	target *Frontend
+
+	// types are the message types proxied, empty proxies all of them
+	types map[string]struct{}
+
+	// metrics tracks the queue of the proxy (optional)
+	metrics *metrics
+
+	// lock protects the replay buffer and the state of the proxy
+	lock sync.Mutex
+
+	// replay is the latest message of each of the proxyReplayTypes
+	replay map[string][]byte
+
+	state ProxyState
 }
 
-func newWsProxy(logger hclog.Logger, downstream *websocket.Conn, proxyAddr string) *wsProxy {
+func newWsProxy(logger hclog.Logger, downstream wsWriter, proxyAddr string) *wsProxy {
 	if logger == nil {
 		logger = hclog.NewNullLogger()
 	}
@@ -37,43 +278,110 @@ func newWsProxy(logger hclog.Logger, downstream *websocket.Conn, proxyAddr strin
 		logger:     logger,
 		downstream: downstream,
 		closeCh:    make(chan struct{}),
-		msgCh:      make(chan []byte, 1000),
+		doneCh:     make(chan struct{}),
+		msgCh:      make(chan []byte, proxyQueueSize),
 		proxyAddr:  proxyAddr,
+		replay:     map[string][]byte{},
 	}
 	return w
 }
 
-func (p *wsProxy) Proxy(data []byte) {
+// accepts returns whether the messages of the type are proxied
+func (p *wsProxy) accepts(typ string) bool {
+	if len(p.types) == 0 {
+		return true
+	}
+	_, ok := p.types[typ]
+	return ok
+}
+
+// Proxy queues the message of the given type to be sent to the frontend
+func (p *wsProxy) Proxy(typ string, data []byte) {
+	for _, replayType := range proxyReplayTypes {
+		if typ == replayType {
+			p.lock.Lock()
+			p.replay[typ] = data
+			p.lock.Unlock()
+		}
+	}
+
 	// we do not want to block the server if the proxy
 	// cannot rely data (i.e. the frontend is down).
 	select {
 	case p.msgCh <- data:
+		p.metrics.setProxyQueueDepth(p.nodeID, p.frontend, len(p.msgCh))
 	default:
+		p.dropped("queue_full")
 	}
 }
 
+func (p *wsProxy) dropped(reason string) {
+	p.lock.Lock()
+	p.state.Dropped++
+	p.lock.Unlock()
+
+	p.metrics.proxyMessageDropped(p.nodeID, p.frontend, reason)
+}
+
+// State returns the current state of the proxy
+func (p *wsProxy) State() *ProxyState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	state := p.state
+	state.NodeID = p.nodeID
+	state.Frontend = p.frontend
+	state.Addr = p.proxyAddr
+	state.QueueDepth = len(p.msgCh)
+	state.QueueSize = cap(p.msgCh)
+	return &state
+}
+
 func (p *wsProxy) close() {
 	close(p.closeCh)
 }
 
-func (p *wsProxy) connect() chan struct{} {
-	for {
-		conn, _, err := websocket.DefaultDialer.Dial(p.proxyAddr, nil)
-		if err != nil {
-			p.logger.Error("failed to dial upstream", "addr", p.proxyAddr, "err", err)
-			time.Sleep(1 * time.Second)
-		} else {
+// connect dials the frontend until it succeeds, waiting an increasing backoff
+// between the attempts. It returns false if the proxy is closed in the meantime.
+func (p *wsProxy) connect() (chan struct{}, bool) {
+	var conn *websocket.Conn
+	for attempt := 0; ; attempt++ {
+		var err error
+		if conn, _, err = websocket.DefaultDialer.Dial(p.proxyAddr, nil); err == nil {
 			p.upstream = conn
 			break
 		}
+
+		backoff := proxyBackoff(attempt)
+		p.logger.Error("failed to dial upstream", "addr", p.proxyAddr, "err", err, "retry", backoff)
+
+		p.lock.Lock()
+		p.state.LastError = err.Error()
+		p.lock.Unlock()
+
+		select {
+		case <-time.After(backoff):
+		case <-p.closeCh:
+			return nil, false
+		}
 	}
 
+	now := time.Now().UTC()
+	p.lock.Lock()
+	if p.state.ConnectedAt != nil {
+		p.state.Reconnects++
+		p.metrics.proxyReconnected(p.nodeID, p.frontend)
+	}
+	p.state.Connected = true
+	p.state.ConnectedAt = &now
+	p.lock.Unlock()
+
 	connCloseCh := make(chan struct{})
 
 	// read any message from upstream server and relay back to Bor
 	go func() {
 		for {
-			mt, message, err := p.upstream.ReadMessage()
+			mt, message, err := conn.ReadMessage()
 			if err != nil {
 				close(connCloseCh)
 				return
@@ -93,11 +401,69 @@ func (p *wsProxy) connect() chan struct{} {
 	}()
 
 	p.logger.Debug("proxy connected")
-	return connCloseCh
+	return connCloseCh, true
+}
+
+// disconnected closes the connection with the frontend after an error
+func (p *wsProxy) disconnected(err error) {
+	p.lock.Lock()
+	p.state.Connected = false
+	if err != nil {
+		p.state.LastError = err.Error()
+	}
+	p.lock.Unlock()
+
+	if err := p.upstream.Close(); err != nil {
+		p.logger.Debug("failed to close upstream", "err", err)
+	}
+	p.upstream = nil
+}
+
+// flush sends the queued messages and a close frame to the frontend before
+// the proxy stops, the messages not sent within proxyFlushTimeout are dropped
+func (p *wsProxy) flush() {
+	p.upstream.SetWriteDeadline(time.Now().Add(proxyFlushTimeout))
+	for {
+		select {
+		case msg := <-p.msgCh:
+			if err := p.upstream.WriteMessage(websocket.TextMessage, msg); err != nil {
+				p.logger.Error("failed to flush upstream message", "err", err)
+				p.dropped("write_error")
+				return
+			}
+			p.lock.Lock()
+			p.state.Sent++
+			p.lock.Unlock()
+
+		default:
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			if err := p.upstream.WriteMessage(websocket.CloseMessage, closeMsg); err != nil {
+				p.logger.Debug("failed to send close upstream", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// replayMsgs returns the latest messages to send again after a connect
+func (p *wsProxy) replayMsgs() [][]byte {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	msgs := [][]byte{}
+	for _, typ := range proxyReplayTypes {
+		if msg, ok := p.replay[typ]; ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
 }
 
 func (p *wsProxy) start(infoMsg []byte) {
 	defer func() {
+		defer close(p.doneCh)
+		p.metrics.proxyClosed(p.nodeID, p.frontend)
+
 		// close the websocket connection (if open)
 		if p.upstream != nil {
 			if err := p.upstream.Close(); err != nil {
@@ -106,29 +472,53 @@ func (p *wsProxy) start(infoMsg []byte) {
 		}
 	}()
 
+	// the messages received before the first connect are still queued,
+	// the latest ones are only sent again after a reconnect
+	reconnect := false
+
 CONNECT:
 	// try to connect with the frontend node
-	connCloseCh := p.connect()
+	connCloseCh, ok := p.connect()
+	if !ok {
+		return
+	}
 
-	// send the infoMsg as initial message always after a connect. Only log if error,
-	// and let the select group to handle any reconnects
-	if err := p.upstream.WriteMessage(websocket.TextMessage, infoMsg); err != nil {
-		p.logger.Error("failed to send info msg", "err", err)
+	// send the infoMsg as initial message always after a connect (followed by the latest block
+	// and stats after a reconnect). Only log if error, and let the select group to handle any reconnects
+	initialMsgs := [][]byte{infoMsg}
+	if reconnect {
+		initialMsgs = append(initialMsgs, p.replayMsgs()...)
+	}
+	reconnect = true
+
+	for _, msg := range initialMsgs {
+		if err := p.upstream.WriteMessage(websocket.TextMessage, msg); err != nil {
+			p.logger.Error("failed to send initial msg", "err", err)
+			break
+		}
 	}
 
 	for {
 		select {
 		case msg := <-p.msgCh:
+			p.metrics.setProxyQueueDepth(p.nodeID, p.frontend, len(p.msgCh))
 			if err := p.upstream.WriteMessage(websocket.TextMessage, msg); err != nil {
 				p.logger.Error("failed to write upstream message", "err", err)
+				p.dropped("write_error")
+				p.disconnected(err)
 				goto CONNECT
 			}
+			p.lock.Lock()
+			p.state.Sent++
+			p.lock.Unlock()
 
 		case <-connCloseCh:
 			p.logger.Debug("proxy stopped")
+			p.disconnected(nil)
 			goto CONNECT
 
 		case <-p.closeCh:
+			p.flush()
 			return
 		}
 	}
@@ -136,38 +526,140 @@ CONNECT:
 
 type sessionManager interface {
 	handleMessage(nodeID string, msg *Msg)
+
+	// sessionStarted and sessionClosed are called when a node authenticates and when its
+	// connection is closed. The session is rejected if sessionStarted returns an error.
+	sessionStarted(session *wsSession) error
+	sessionClosed(session *wsSession)
 }
 
 var loggedMessage = []byte(`{
 	"emit": ["ready"]
 }`)
 
-// pong message needs to send two messages (second is not read)
-var pongMessage = []byte(`{
-	"emit": [
-		"node-pong",
-		{}
-	]
-}`)
+// pongMessage returns the node-pong reply to a node-ping. The pong echoes the
+// 'id' and 'clientTime' of the ping so that the node can measure the latency.
+func pongMessage(ping *Msg) ([]byte, error) {
+	pong := &Msg{typ: "node-pong"}
+	for _, field := range []string{"id", "clientTime"} {
+		if val, ok := ping.msg[field]; ok {
+			pong.Set(field, val)
+		}
+	}
+	pong.Set("serverTime", json.RawMessage(strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)))
+	return pong.Marshal()
+}
 
 type wsCollector struct {
-	logger      hclog.Logger
-	proxyAddr   string
-	proxySecret string
-	secret      string
-	manager     sessionManager
+	logger hclog.Logger
+
+	// frontendsLock protects the frontends since they are replaced when the config is reloaded
+	frontendsLock sync.RWMutex
+	frontends     []*Frontend
+
+	auth    *nodeAuth
+	manager sessionManager
+	metrics *metrics
+
+	// idleTimeout closes the sessions that do not send messages (if not zero)
+	idleTimeout time.Duration
 }
 
-func (c *wsCollector) handle(conn *websocket.Conn) {
-	c.logger.Debug("new connection opened", "proxyEnabled", c.proxyAddr != "")
+// newProxy creates the proxy of the node messages to the frontend
+func (c *wsCollector) newProxy(frontend *Frontend, session *wsSession) *wsProxy {
+	proxy := newWsProxy(c.logger.Named("proxy_"+session.nodeID).With("frontend", frontend.Name), session, frontend.Addr)
+	proxy.nodeID = session.nodeID
+	proxy.frontend = frontend.Name
+	proxy.target = frontend
+	proxy.metrics = c.metrics
+	if len(frontend.Types) != 0 {
+		proxy.types = map[string]struct{}{}
+		for _, typ := range frontend.Types {
+			proxy.types[typ] = struct{}{}
+		}
+	}
+	return proxy
+}
+
+// proxyHello returns the hello of the node sent to the frontend
+func proxyHello(frontend *Frontend, hello *Msg, helloMsg []byte) []byte {
+	// use the secret from the proxy
+	proxyMsg := helloMsg
+	if frontend.Secret != "" {
+		proxyAuthMsg := hello.Copy()
+		proxyAuthMsg.Set("secret", []byte(`"`+frontend.Secret+`"`))
+		proxyMsg, _ = proxyAuthMsg.Marshal()
+	}
+	return proxyMsg
+}
+
+// currentFrontends returns the frontends the messages of the nodes are proxied to
+func (c *wsCollector) currentFrontends() []*Frontend {
+	c.frontendsLock.RLock()
+	defer c.frontendsLock.RUnlock()
+
+	return c.frontends
+}
+
+// setFrontends replaces the frontends, the sessions have to be synced afterwards
+func (c *wsCollector) setFrontends(frontends []*Frontend) {
+	c.frontendsLock.Lock()
+	defer c.frontendsLock.Unlock()
+
+	c.frontends = frontends
+}
+
+// syncProxies updates the proxies of the session to the current frontends. The
+// proxies of the frontends that did not change are kept, the others are closed
+// and the proxies of the new frontends are started with the hello of the node.
+func (c *wsCollector) syncProxies(session *wsSession) {
+	frontends := c.currentFrontends()
+
+	session.proxiesLock.Lock()
+	defer session.proxiesLock.Unlock()
+
+	// the proxies are started once the node is logged
+	if session.hello == nil || session.proxiesClosed {
+		return
+	}
+
+	current := map[string]*wsProxy{}
+	for _, proxy := range session.proxies {
+		current[proxy.frontend] = proxy
+	}
+	proxies := []*wsProxy{}
+	for _, frontend := range frontends {
+		if proxy, ok := current[frontend.Name]; ok && proxy.target.equal(frontend) {
+			delete(current, frontend.Name)
+			proxies = append(proxies, proxy)
+			continue
+		}
+		proxy := c.newProxy(frontend, session)
+		go proxy.start(proxyHello(frontend, session.hello, session.helloData))
+		proxies = append(proxies, proxy)
+	}
+	for _, proxy := range current {
+		proxy.close()
+	}
+	session.proxies = proxies
+}
 
-	// start the proxy to the upstream repo (if any)
-	var proxy *wsProxy
+func (c *wsCollector) handle(conn *websocket.Conn) {
+	c.logger.Debug("new connection opened", "frontends", len(c.currentFrontends()))
 
 	logged := false
 	var nodeID string
+	var session *wsSession
 
 	defer func() {
+		if logged {
+			c.metrics.nodeDisconnected()
+			c.manager.sessionClosed(session)
+		}
+		// the proxies to the upstream frontends (if any) are stopped with the session
+		if session != nil {
+			session.closeProxies()
+		}
 		conn.Close()
 	}()
 
@@ -182,64 +674,79 @@ func (c *wsCollector) handle(conn *websocket.Conn) {
 		if err := msg.decodeMsg("secret", &secret); err != nil {
 			return err
 		}
-		if c.secret != "" && secret != c.secret {
-			return fmt.Errorf("secret is not correct: %s", secret)
-		}
-
 		var info NodeInfo
 		if err := msg.decodeMsg("info", &info); err != nil {
 			return err
 		}
-
-		if err := conn.WriteMessage(websocket.TextMessage, loggedMessage); err != nil {
+		if err := c.auth.authenticate(info.Name, secret); err != nil {
 			return err
 		}
 		nodeID = info.Name
-		return nil
+
+		session = newWsSession(nodeID, conn)
+		session.secret = secret
+		session.remoteAddr = conn.RemoteAddr().String()
+
+		// only one session of each node is accepted at the same time
+		return c.manager.sessionStarted(session)
 	}
 
 	for {
+		// the nodes that do not send any message within the idle timeout are disconnected
+		if c.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+		}
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			c.logger.Debug("failed to read msg", "err", err)
+			if session != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					session.setCloseReason(SessionReasonIdle)
+				} else {
+					session.setCloseReason(SessionReasonClosed)
+				}
+			}
 			break
 		}
 
 		msg, err := DecodeMsg(message)
 		if err != nil {
 			c.logger.Error("failed to decode msg", "err", err)
+			c.metrics.decodeError()
 			continue
 		}
 
 		c.logger.Debug("new message", "node", nodeID, "typ", msg.typ)
+		c.metrics.messageReceived(msg.typ)
 
 		if !logged {
 			// auth the node
 			if err := handleAuth(msg); err != nil {
 				c.logger.Error("failed to handle auth", "err", err)
+				c.metrics.authFailure()
 				break
 			}
+			c.metrics.nodeConnected()
+			logged = true
 
-			if c.proxyAddr != "" {
-				proxy = newWsProxy(c.logger.Named("proxy_"+nodeID), conn, c.proxyAddr)
-
-				// use the secret from the proxy
-				proxyMsg := message
-				if c.proxySecret != "" {
-					proxyAuthMsg := msg.Copy()
-					proxyAuthMsg.Set("secret", []byte(`"`+c.proxySecret+`"`))
-					proxyMsg, _ = proxyAuthMsg.Marshal()
-				}
-
-				go proxy.start(proxyMsg)
-				defer proxy.close()
+			if err := session.WriteMessage(websocket.TextMessage, loggedMessage); err != nil {
+				c.logger.Error("failed to write message", "err", err)
+				break
 			}
-			logged = true
+			// start the proxies to the upstream frontends (if any), each with its own queue and connection
+			session.setHello(msg, message)
+			c.syncProxies(session)
 		}
+		session.received(msg.typ)
 
 		if msg.msgType() == "node-ping" {
 			// send a pong
-			if err := conn.WriteMessage(websocket.TextMessage, pongMessage); err != nil {
+			pong, err := pongMessage(msg)
+			if err != nil {
+				c.logger.Error("failed to encode pong", "err", err)
+				continue
+			}
+			if err := session.WriteMessage(websocket.TextMessage, pong); err != nil {
 				c.logger.Error("failed to write message", "err", err)
 				break
 			}
@@ -247,11 +754,15 @@ func (c *wsCollector) handle(conn *websocket.Conn) {
 
 		// deliver the message to the session
 		if msg.typ != "node-ping" {
-			// deliver the message to the proxy. We do not send neither:
+			// deliver the message to the proxies. We do not send neither:
 			// - node-ping: since we do not want to proxy back pong.
 			// - hello: since we have already sent hello ourselves to the proxy
-			if proxy != nil && msg.typ != "hello" {
-				proxy.Proxy(message)
+			if msg.typ != "hello" {
+				for _, proxy := range session.getProxies() {
+					if proxy.accepts(msg.typ) {
+						proxy.Proxy(msg.typ, message)
+					}
+				}
 			}
 
 			c.manager.handleMessage(nodeID, msg)
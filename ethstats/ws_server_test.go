@@ -2,9 +2,12 @@ package ethstats
 
 import (
 	"context"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -28,14 +31,32 @@ type mockWsServer struct {
 	addr     string
 	srv      *http.Server
 	cancelFn context.CancelFunc
+
+	// conns are the websocket connections, which are hijacked and not closed by
+	// the http server, and handlers are the handlers still running on them
+	lock     sync.Mutex
+	conns    []*websocket.Conn
+	handlers sync.WaitGroup
+	closed   bool
 }
 
+// close stops the server, closes the connections and waits for their handlers
+// to return, so they do not outlive the test
 func (m *mockWsServer) close() {
 	m.cancelFn()
 
 	if err := m.srv.Shutdown(context.Background()); err != nil {
 		m.t.Fatal(err)
 	}
+
+	m.lock.Lock()
+	m.closed = true
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+	m.lock.Unlock()
+
+	m.handlers.Wait()
 }
 
 type wsChHandler struct {
@@ -81,13 +102,22 @@ func newMockWsServer(t *testing.T, addr string, handler func(ctx context.Context
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		upgrader.CheckOrigin = func(r *http.Request) bool {
-			return true
-		}
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			return
 		}
+
+		m.lock.Lock()
+		if m.closed {
+			m.lock.Unlock()
+			c.Close()
+			return
+		}
+		m.conns = append(m.conns, c)
+		m.handlers.Add(1)
+		m.lock.Unlock()
+
+		defer m.handlers.Done()
 		handler(ctx, c)
 	})
 
@@ -195,7 +225,7 @@ func TestWsProxy(t *testing.T) {
 				// closed conn
 				return
 			} else {
-				proxy.Proxy(msg)
+				proxy.Proxy("", msg)
 			}
 		}
 	})
@@ -223,6 +253,119 @@ func TestWsProxy(t *testing.T) {
 	assert.Equal(t, recv(1*time.Second), msg2)
 }
 
+func TestProxyBackoff(t *testing.T) {
+	for attempt, expected := range []time.Duration{proxyMinBackoff, 2 * proxyMinBackoff, 4 * proxyMinBackoff} {
+		backoff := proxyBackoff(attempt)
+		assert.GreaterOrEqual(t, int64(backoff), int64(expected/2))
+		assert.LessOrEqual(t, int64(backoff), int64(expected))
+	}
+	assert.LessOrEqual(t, int64(proxyBackoff(1000)), int64(proxyMaxBackoff))
+}
+
+func TestWsProxy_Replay(t *testing.T) {
+	var (
+		block1  = []byte{0x1}
+		block2  = []byte{0x2}
+		stats   = []byte{0x3}
+		infoMsg = []byte{0x4}
+	)
+
+	echoCh := &wsChHandler{
+		recvCh: make(chan []byte, 10),
+	}
+	recv := func() []byte {
+		select {
+		case msg := <-echoCh.recvCh:
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+		return nil
+	}
+
+	upstream := newMockWsServer(t, "", echoCh.handle)
+
+	proxy := newWsProxy(nil, &mockWsWriter{}, upstream.addr)
+	defer proxy.close()
+	go proxy.start(infoMsg)
+
+	proxy.Proxy("block", block1)
+	proxy.Proxy("stats", stats)
+	proxy.Proxy("block", block2)
+
+	assert.Equal(t, recv(), infoMsg)
+	assert.Equal(t, recv(), block1)
+	assert.Equal(t, recv(), stats)
+	assert.Equal(t, recv(), block2)
+
+	// restart upstream connection
+	upstream.close()
+	upstream = newMockWsServer(t, strings.TrimPrefix(upstream.addr, "ws://"), echoCh.handle)
+	defer upstream.close()
+
+	// the latest block and stats are sent again after the hello
+	assert.Equal(t, recv(), infoMsg)
+	assert.Equal(t, recv(), block2)
+	assert.Equal(t, recv(), stats)
+
+	state := proxy.State()
+	assert.True(t, state.Connected)
+	assert.Equal(t, state.Sent, uint64(3))
+	assert.Equal(t, state.Reconnects, uint64(1))
+}
+
+func TestWsProxy_QueueFull(t *testing.T) {
+	// the proxy is not started so the messages are not sent
+	proxy := newWsProxy(nil, &mockWsWriter{}, "ws://localhost:1")
+	proxy.nodeID = "a"
+	proxy.frontend = "public"
+
+	for i := 0; i < proxyQueueSize+5; i++ {
+		proxy.Proxy("stats", []byte(strconv.Itoa(i)))
+	}
+
+	state := proxy.State()
+	assert.Equal(t, state.NodeID, "a")
+	assert.Equal(t, state.Frontend, "public")
+	assert.False(t, state.Connected)
+	assert.Equal(t, state.QueueDepth, proxyQueueSize)
+	assert.Equal(t, state.Dropped, uint64(5))
+
+	// the dropped messages are still kept for the replay
+	assert.Equal(t, proxy.replayMsgs(), [][]byte{[]byte(strconv.Itoa(proxyQueueSize + 4))})
+}
+
+func TestWsProxy_Flush(t *testing.T) {
+	frontend := &wsChHandler{recvCh: make(chan []byte, 10)}
+	frontendSrv := newMockWsServer(t, "", frontend.handle)
+	defer frontendSrv.close()
+
+	proxy := newWsProxy(nil, &mockWsWriter{}, frontendSrv.addr)
+	for i := 0; i < 3; i++ {
+		proxy.Proxy("stats", []byte(strconv.Itoa(i)))
+	}
+
+	// the messages queued when the proxy is closed are still sent
+	proxy.close()
+	proxy.start([]byte("hello"))
+
+	for _, expected := range []string{"hello", "0", "1", "2"} {
+		select {
+		case msg := <-frontend.recvCh:
+			assert.Equal(t, string(msg), expected)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	assert.Equal(t, proxy.State().Sent, uint64(3))
+
+	select {
+	case <-proxy.doneCh:
+	default:
+		t.Fatal("proxy not done")
+	}
+}
+
 type mockSessionManager struct {
 	ch chan *Msg
 }
@@ -235,6 +378,13 @@ func (m *mockSessionManager) handleMessage(nodeID string, msg *Msg) {
 	m.ch <- msg
 }
 
+func (m *mockSessionManager) sessionStarted(session *wsSession) error {
+	return nil
+}
+
+func (m *mockSessionManager) sessionClosed(session *wsSession) {
+}
+
 func TestWsCollector_Session(t *testing.T) {
 	sm := newMockSessionManager()
 
@@ -271,10 +421,9 @@ func TestWsCollector_ProxySecret(t *testing.T) {
 		upstream := newMockWsServer(t, "", echoCh.handle)
 
 		ws := &wsCollector{
-			manager:     sm,
-			logger:      hclog.NewNullLogger(),
-			proxySecret: secret,
-			proxyAddr:   upstream.addr,
+			manager:   sm,
+			logger:    hclog.NewNullLogger(),
+			frontends: []*Frontend{{Name: "a", Addr: upstream.addr, Secret: secret}},
 		}
 
 		srv := newMockWsServer(t, "", func(ctx context.Context, conn *websocket.Conn) {
@@ -303,6 +452,112 @@ func TestWsCollector_ProxySecret(t *testing.T) {
 	}
 }
 
+func TestWsCollector_Frontends(t *testing.T) {
+	sm := newMockSessionManager()
+
+	public := &wsChHandler{recvCh: make(chan []byte, 10)}
+	publicSrv := newMockWsServer(t, "", public.handle)
+	defer publicSrv.close()
+
+	internal := &wsChHandler{recvCh: make(chan []byte, 10)}
+	internalSrv := newMockWsServer(t, "", internal.handle)
+	defer internalSrv.close()
+
+	ws := &wsCollector{
+		manager: sm,
+		logger:  hclog.NewNullLogger(),
+		frontends: []*Frontend{
+			{Name: "public", Addr: publicSrv.addr, Secret: "public", Types: []string{"block"}},
+			{Name: "internal", Addr: internalSrv.addr, Secret: "internal"},
+		},
+	}
+	srv := newMockWsServer(t, "", func(ctx context.Context, conn *websocket.Conn) {
+		ws.handle(conn)
+	})
+	defer srv.close()
+
+	clt := newMockWsClient(t, srv.addr)
+	defer clt.close()
+
+	clt.emit("hello", `{
+		"secret": "secret",
+		"info": {}
+	}`)
+	clt.emit("stats", `{}`)
+	clt.emit("block", `{}`)
+
+	recv := func(ch chan []byte) *Msg {
+		select {
+		case raw := <-ch:
+			msg, err := DecodeMsg(raw)
+			assert.NoError(t, err)
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+		return nil
+	}
+
+	// each frontend gets the hello with its own secret
+	for ch, secret := range map[chan []byte]string{public.recvCh: "public", internal.recvCh: "internal"} {
+		hello := recv(ch)
+		assert.Equal(t, hello.typ, "hello")
+
+		var foundSecret string
+		assert.NoError(t, hello.decodeMsg("secret", &foundSecret))
+		assert.Equal(t, foundSecret, secret)
+	}
+
+	// the public frontend only gets the blocks
+	assert.Equal(t, recv(public.recvCh).typ, "block")
+	assert.Equal(t, recv(internal.recvCh).typ, "stats")
+	assert.Equal(t, recv(internal.recvCh).typ, "block")
+}
+
+func TestWsCollector_Auth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"a": "secret-a", "b": "secret-b"}`), 0600))
+
+	auth, err := newNodeAuth("", path, nil, nil)
+	assert.NoError(t, err)
+
+	srv := newTestServer(t)
+	ws := &wsCollector{
+		manager: srv,
+		logger:  hclog.NewNullLogger(),
+		auth:    auth,
+	}
+	collector := newMockWsServer(t, "", func(ctx context.Context, conn *websocket.Conn) {
+		ws.handle(conn)
+	})
+	defer collector.close()
+
+	login := func(name, secret string) (*mockWsClient, error) {
+		clt := newMockWsClient(t, collector.addr)
+		clt.emit("hello", `{
+			"secret": "`+secret+`",
+			"info": {"name": "`+name+`"}
+		}`)
+		_, _, err := clt.conn.ReadMessage()
+		return clt, err
+	}
+
+	// a node cannot use the secret of another node
+	_, err = login("a", "secret-b")
+	assert.Error(t, err)
+
+	clt, err := login("a", "secret-a")
+	assert.NoError(t, err)
+	defer clt.close()
+
+	// a second session of a connected node is rejected
+	_, err = login("a", "secret-a")
+	assert.Error(t, err)
+
+	_, err = login("b", "secret-b")
+	assert.NoError(t, err)
+}
+
 func TestWsCollector_PingPong(t *testing.T) {
 	sm := newMockSessionManager()
 
@@ -320,11 +575,22 @@ func TestWsCollector_PingPong(t *testing.T) {
 		"info": {}
 	}`)
 
-	clt.emit("node-ping", `{}`)
+	clt.emit("node-ping", `{"id": "a", "clientTime": "2022-01-01 10:00:00"}`)
 
 	// expect a ready message
 	assert.Equal(t, clt.readMsg().typ, "ready")
 
-	// expect a pong message
-	assert.Equal(t, clt.readMsg().typ, "node-pong")
+	// expect a pong message that echoes the ping
+	pong := clt.readMsg()
+	assert.Equal(t, pong.typ, "node-pong")
+
+	var id, clientTime string
+	assert.NoError(t, pong.decodeMsg("id", &id))
+	assert.NoError(t, pong.decodeMsg("clientTime", &clientTime))
+	assert.Equal(t, id, "a")
+	assert.Equal(t, clientTime, "2022-01-01 10:00:00")
+
+	var serverTime int64
+	assert.NoError(t, pong.decodeMsg("serverTime", &serverTime))
+	assert.NotZero(t, serverTime)
 }
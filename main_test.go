@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadServerConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`
+collector.addr: 0.0.0.0:9000
+collector.idle-timeout: 2m
+frontend:
+  - name: public
+    addr: ws://localhost:3000/api
+  - name: internal
+    addr: ws://localhost:3001/api
+alerts.webhook:
+  - url: http://localhost/file
+`), 0600))
+
+	config, _, err := loadServerConfig([]string{"-config", path}, "memory://")
+	assert.NoError(t, err)
+	assert.Equal(t, config.CollectorAddr, "0.0.0.0:9000")
+	assert.Len(t, config.Frontends, 2)
+	assert.Len(t, config.Alerts.Webhooks, 1)
+
+	// the flags override the file, the repeatable ones replace all the items of the file
+	config, _, err = loadServerConfig([]string{
+		"-config", path,
+		"-collector.idle-timeout", "30s",
+		"-frontend", "name=cli,addr=ws://localhost:4000/api",
+		"-alerts.webhook", "url=http://localhost/cli",
+		"-alerts.webhook", "url=http://localhost/other",
+	}, "memory://")
+	assert.NoError(t, err)
+	assert.Equal(t, config.CollectorAddr, "0.0.0.0:9000")
+	assert.Equal(t, config.SessionIdleTimeout.String(), "30s")
+	assert.Len(t, config.Frontends, 1)
+	assert.Equal(t, config.Frontends[0].Name, "cli")
+	assert.Len(t, config.Alerts.Webhooks, 2)
+	assert.Equal(t, config.Alerts.Webhooks[0].URL, "http://localhost/cli")
+
+	_, _, err = loadServerConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, "memory://")
+	assert.Error(t, err)
+}